@@ -0,0 +1,7 @@
+# Packages already built into the provider's dir can be imported by
+# name-version-rEPOCH.
+terraform import melange_build.hello hello-1.2.3-r0
+
+# Importing by the path to the package's configuration also sets config,
+# config_contents and config_dir, as melange_config's config_file does.
+terraform import melange_build.hello hello.yaml
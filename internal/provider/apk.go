@@ -0,0 +1,152 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// apkFile describes a file in the data section of an APK.
+type apkFile struct {
+	Digest  string // sha256 of regular file contents, or the link target
+	Mode    int64
+	ModTime time.Time
+	Data    []byte // the file's contents, if requested
+}
+
+// readAPK returns the datahash recorded in the APK's .PKGINFO, along with the
+// files in its data section. The contents of regular files for which keep
+// returns true are included.
+//
+// An APK is a concatenation of gzip streams: an optional signature, the
+// control section containing .PKGINFO, and the data section.
+func readAPK(path string, keep func(name string) bool) (string, map[string]apkFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	var datahash string
+	for {
+		zr.Multistream(false)
+		files, err := readTar(zr, func(name string) bool {
+			return name == ".PKGINFO" || (datahash != "" && keep != nil && keep(name))
+		})
+		if err != nil {
+			return "", nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if pkginfo, ok := files[".PKGINFO"]; ok {
+			datahash = parsePkgInfo(pkginfo.Data)["datahash"]
+		} else if datahash != "" {
+			// The section after the control section is the data section.
+			return datahash, files, nil
+		}
+
+		if err := zr.Reset(br); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	return "", nil, fmt.Errorf("reading %s: no data section found", path)
+}
+
+// readPkgInfo returns the fields of the APK's .PKGINFO, without reading its
+// data section.
+func readPkgInfo(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	for {
+		zr.Multistream(false)
+		files, err := readTar(zr, func(name string) bool { return name == ".PKGINFO" })
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if pkginfo, ok := files[".PKGINFO"]; ok {
+			return parsePkgInfo(pkginfo.Data), nil
+		}
+		if err := zr.Reset(br); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	return nil, fmt.Errorf("reading %s: no .PKGINFO found", path)
+}
+
+// parsePkgInfo parses the `key = value` lines of a .PKGINFO. Only the last
+// value of repeated keys, like depend, is kept.
+func parsePkgInfo(b []byte) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		if k, v, ok := strings.Cut(line, " = "); ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// readTar reads a tar stream, returning the files in it, including the
+// contents of regular files for which keep returns true. Streams that aren't
+// complete tar archives, like the control section, are read to the end.
+func readTar(r io.Reader, keep func(name string) bool) (map[string]apkFile, error) {
+	files := map[string]apkFile{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		af := apkFile{Mode: hdr.Mode, ModTime: hdr.ModTime}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			h := sha256.New()
+			if keep(hdr.Name) {
+				b, err := io.ReadAll(io.TeeReader(tr, h))
+				if err != nil {
+					return nil, err
+				}
+				af.Data = b
+			} else if _, err := io.Copy(h, tr); err != nil {
+				return nil, err
+			}
+			af.Digest = fmt.Sprintf("sha256:%x", h.Sum(nil))
+		case tar.TypeSymlink, tar.TypeLink:
+			af.Digest = "-> " + hdr.Linkname
+		}
+		files[hdr.Name] = af
+	}
+	// Drain anything after the end of the archive, so the next gzip stream
+	// can be found.
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
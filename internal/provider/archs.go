@@ -0,0 +1,75 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"fmt"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"gopkg.in/yaml.v2"
+)
+
+// targetArchitectures returns the package's target-architecture from the
+// melange configuration, or nil if it isn't restricted.
+func targetArchitectures(contents []byte) ([]string, error) {
+	var cfg struct {
+		Package struct {
+			TargetArchitecture []string `yaml:"target-architecture"`
+		} `yaml:"package"`
+	}
+	if err := yaml.Unmarshal(contents, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Package.TargetArchitecture) == 1 && cfg.Package.TargetArchitecture[0] == "all" {
+		return nil, nil
+	}
+	return cfg.Package.TargetArchitecture, nil
+}
+
+// effectiveArchs returns the architectures to build a package for. The first
+// non-empty list of override, the config's target-architecture, the config's
+// environment archs and the provider's default archs is used. The result is
+// always restricted to the target architectures, since melange refuses to
+// build for any others.
+func effectiveArchs(targets, override, configArchs, defaults []string) ([]apkotypes.Architecture, error) {
+	for _, a := range override {
+		if !knownArch(a) {
+			return nil, fmt.Errorf("unknown architecture %q", a)
+		}
+	}
+
+	candidates := defaults
+	for _, archs := range [][]string{override, targets, configArchs} {
+		if len(archs) != 0 {
+			candidates = archs
+			break
+		}
+	}
+
+	allowed := map[string]bool{}
+	for _, a := range apkotypes.ParseArchitectures(targets) {
+		allowed[a.ToAPK()] = true
+	}
+	seen := map[string]bool{}
+	var out []apkotypes.Architecture
+	for _, a := range apkotypes.ParseArchitectures(candidates) {
+		if seen[a.ToAPK()] || (len(allowed) != 0 && !allowed[a.ToAPK()]) {
+			continue
+		}
+		seen[a.ToAPK()] = true
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// archsValue returns the architectures as a list of their apk names.
+func archsValue(archs []apkotypes.Architecture) basetypes.ListValue {
+	vals := make([]attr.Value, 0, len(archs))
+	for _, a := range archs {
+		vals = append(vals, basetypes.NewStringValue(a.ToAPK()))
+	}
+	return basetypes.NewListValueMust(basetypes.StringType{}, vals)
+}
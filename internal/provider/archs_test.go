@@ -0,0 +1,68 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEffectiveArchs(t *testing.T) {
+	for _, c := range []struct {
+		desc                                     string
+		targets, override, configArchs, defaults []string
+		want                                     []string
+	}{{
+		desc:     "provider defaults",
+		defaults: []string{"x86_64", "aarch64"},
+		want:     []string{"x86_64", "aarch64"},
+	}, {
+		desc:     "no archs anywhere",
+		defaults: nil,
+		want:     nil,
+	}, {
+		desc:        "config archs over defaults",
+		configArchs: []string{"aarch64"},
+		defaults:    []string{"x86_64", "aarch64"},
+		want:        []string{"aarch64"},
+	}, {
+		desc:        "target-architecture over config archs",
+		targets:     []string{"x86_64"},
+		configArchs: []string{"aarch64", "x86_64"},
+		defaults:    []string{"aarch64"},
+		want:        []string{"x86_64"},
+	}, {
+		desc:     "override over everything",
+		override: []string{"amd64", "arm64"},
+		defaults: []string{"x86_64"},
+		want:     []string{"x86_64", "aarch64"},
+	}, {
+		desc:     "override restricted to target-architecture",
+		targets:  []string{"x86_64"},
+		override: []string{"aarch64", "x86_64"},
+		want:     []string{"x86_64"},
+	}, {
+		desc:     "duplicates removed",
+		defaults: []string{"x86_64", "amd64"},
+		want:     []string{"x86_64"},
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			archs, err := effectiveArchs(c.targets, c.override, c.configArchs, c.defaults)
+			if err != nil {
+				t.Fatalf("effectiveArchs: %v", err)
+			}
+			var got []string
+			for _, a := range archs {
+				got = append(got, a.ToAPK())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	if _, err := effectiveArchs(nil, []string{"sparc"}, nil, nil); err == nil {
+		t.Error("expected error for unknown architecture")
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/melange/pkg/index"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	apkrepo "gitlab.alpinelinux.org/alpine/go/repository"
+)
+
+// indexMu serializes changes to the indexes of the local repository, which
+// builds applied or destroyed in parallel may share: builds adding their
+// packages and pruning old versions, and deletes removing them. Builds
+// themselves run in parallel and only hold it once their packages are built,
+// so melange's own index step, which merges into the index without it, is
+// turned off.
+var indexMu sync.Mutex
+
+// Delete removes the APKs the build produced, with their SBOMs, provenance
+// and logs, and their entries in the index, unless retain_on_delete is set.
+//
+// APKs rebuilt since by another build are left alone, so replacing a build
+// with create_before_destroy doesn't remove what its replacement built.
+func (r *BuildResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var data BuildResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if data.RetainOnDelete.ValueBool() {
+		return
+	}
+	if data.ConfigContents.IsNull() {
+		resp.Diagnostics.AddWarning("Packages not removed",
+			"The build was imported by name, so the packages it produced aren't known until it's applied with a configuration, and were left in place.")
+		return
+	}
+
+	r, err := r.withOverrides(ctx, data)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	apks, err := r.apks(data)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	var archs []string
+	resp.Diagnostics.Append(data.EffectiveArchs.ElementsAs(ctx, &archs, false)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if err := r.removeBuilt(ctx, data, apks, archs); err != nil {
+		resp.Diagnostics.AddError("Error removing packages", err.Error())
+	}
+}
+
+// removeBuilt removes the APKs built by the build data for archs, with the
+// files written alongside them and their entries in the index. APKs rebuilt
+// since by another build are left alone.
+func (r *BuildResource) removeBuilt(ctx context.Context, data BuildResourceModel, apks builtAPKs, archs []string) error {
+	for _, a := range archs {
+		arch := apkotypes.ParseArchitecture(a)
+		apkPath := apks.path(arch, apks.names[0])
+		if fp := provenanceBuildFingerprint(apkPath); fp != "" && fp != data.Id.ValueString() {
+			tflog.Info(ctx, fmt.Sprintf("leaving %s in place, it was rebuilt by another build", apkPath))
+			continue
+		}
+		for _, name := range apks.names {
+			if err := removeAPK(apks.path(arch, name)); err != nil {
+				return err
+			}
+		}
+		if err := r.removeFromIndex(ctx, filepath.Dir(apkPath), apks.names, fmt.Sprintf("%s-r%d", apks.version, apks.epoch)); err != nil {
+			return fmt.Errorf("updating index: %w", err)
+		}
+	}
+	return nil
+}
+
+// removeAPK removes the APK at apkPath and the files written alongside it:
+// its SBOM, provenance and build logs.
+func removeAPK(apkPath string) error {
+	matches, err := filepath.Glob(strings.TrimSuffix(apkPath, ".apk") + ".*")
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		if err := os.Remove(m); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFromIndex removes the packages named names at version from the index
+// in dir.
+func (r *BuildResource) removeFromIndex(ctx context.Context, dir string, names []string, version string) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	return r.rewriteIndex(ctx, dir, func(pkgs []*apkrepo.Package) ([]*apkrepo.Package, error) {
+		return withoutPackages(pkgs, names, version), nil
+	})
+}
+
+// rewriteIndex replaces the packages in the index in dir with what update
+// returns, signing it again if the provider has a signing key, and updating
+// the APKINDEX.json melange writes alongside it. The index is removed once
+// it's empty. The caller must hold indexMu.
+func (r *BuildResource) rewriteIndex(ctx context.Context, dir string, update func([]*apkrepo.Package) ([]*apkrepo.Package, error)) error {
+	indexPath := filepath.Join(dir, "APKINDEX.tar.gz")
+	if !fileExists(indexPath) {
+		return nil
+	}
+	opts := []index.Option{index.WithIndexFile(indexPath)}
+	if key := filepath.Join(r.popts.dir, r.popts.signingKey); fileExists(key) {
+		opts = append(opts, index.WithSigningKey(key))
+	}
+	idx, err := index.New(opts...)
+	if err != nil {
+		return err
+	}
+	idx.Logger.SetOutput(io.Discard)
+	if err := idx.LoadIndex(indexPath); err != nil {
+		return err
+	}
+
+	before := len(idx.Index.Packages)
+	if idx.Index.Packages, err = update(idx.Index.Packages); err != nil {
+		return err
+	}
+	jsonPath := filepath.Join(dir, "APKINDEX.json")
+	switch len(idx.Index.Packages) {
+	case before:
+		return nil
+	case 0:
+		if err := os.Remove(jsonPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Remove(indexPath)
+	}
+	if err := idx.WriteArchiveIndex(ctx, indexPath); err != nil {
+		return err
+	}
+	if fileExists(jsonPath) {
+		return idx.WriteJSONIndex(jsonPath)
+	}
+	return nil
+}
+
+// indexPackages adds the APKs built for arch to the index in their
+// directory, replacing any entries for the same packages, and writes the
+// APKINDEX.json alongside it, as melange's index step does. The packages'
+// older versions are then pruned, as retain_versions says.
+func (r *BuildResource) indexPackages(ctx context.Context, apks builtAPKs, arch apkotypes.Architecture) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+
+	dir := filepath.Dir(apks.path(arch, apks.names[0]))
+	var files []string
+	for _, name := range apks.names {
+		// Not every subpackage produces an APK.
+		if p := apks.path(arch, name); fileExists(p) {
+			files = append(files, p)
+		}
+	}
+	opts := []index.Option{
+		index.WithPackageFiles(files),
+		index.WithMergeIndexFileFlag(true),
+		index.WithIndexFile(filepath.Join(dir, "APKINDEX.tar.gz")),
+	}
+	if key := filepath.Join(r.popts.dir, r.popts.signingKey); fileExists(key) {
+		opts = append(opts, index.WithSigningKey(key))
+	}
+	idx, err := index.New(opts...)
+	if err != nil {
+		return err
+	}
+	idx.Logger.SetOutput(io.Discard)
+	if err := idx.GenerateIndex(ctx); err != nil {
+		return fmt.Errorf("generating index: %w", err)
+	}
+	if err := idx.WriteJSONIndex(filepath.Join(dir, "APKINDEX.json")); err != nil {
+		return err
+	}
+	return r.pruneVersions(ctx, dir, apks.names, fmt.Sprintf("%s-r%d", apks.version, apks.epoch))
+}
+
+// withoutPackages returns pkgs without the packages named names at version.
+func withoutPackages(pkgs []*apkrepo.Package, names []string, version string) []*apkrepo.Package {
+	remove := make(map[string]bool, len(names))
+	for _, n := range names {
+		remove[n] = true
+	}
+	var kept []*apkrepo.Package
+	for _, p := range pkgs {
+		if !(remove[p.Name] && p.Version == version) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,65 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	apkrepo "gitlab.alpinelinux.org/alpine/go/repository"
+)
+
+func TestRemoveAPK(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{
+		"foo-1.0-r0.apk",
+		"foo-1.0-r0.spdx.json",
+		"foo-1.0-r0.intoto.json",
+		"foo-1.0-r0.log",
+		"foo-1.0-r0.attempt-1.log",
+		"foo-1.0-r1.apk",
+		"foo-bar-1.0-r0.apk",
+		"APKINDEX.tar.gz",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := removeAPK(filepath.Join(dir, "foo-1.0-r0.apk")); err != nil {
+		t.Fatalf("removeAPK: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	sort.Strings(got)
+	want := []string{"APKINDEX.tar.gz", "foo-1.0-r1.apk", "foo-bar-1.0-r0.apk"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWithoutPackages(t *testing.T) {
+	pkgs := []*apkrepo.Package{
+		{Name: "foo", Version: "1.0-r0"},
+		{Name: "foo-dev", Version: "1.0-r0"},
+		{Name: "foo", Version: "1.1-r0"},
+		{Name: "bar", Version: "1.0-r0"},
+	}
+	var got []string
+	for _, p := range withoutPackages(pkgs, []string{"foo", "foo-dev"}, "1.0-r0") {
+		got = append(got, p.Name+"-"+p.Version)
+	}
+	want := []string{"foo-1.1-r0", "bar-1.0-r0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
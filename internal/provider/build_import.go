@@ -0,0 +1,227 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"gopkg.in/yaml.v2"
+)
+
+// ImportState adopts packages that were already built into the provider's
+// dir. The ID is either the package's name-version-rEPOCH, or the path to its
+// melange configuration, in which case config, config_contents and
+// config_dir are set too. Read then finds the packages.
+func (r *BuildResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	if ext := filepath.Ext(req.ID); ext == ".yaml" || ext == ".yml" {
+		data, err := r.importConfig(ctx, req.ID)
+		if err != nil {
+			resp.Diagnostics.AddError("Unable to import build", err.Error())
+			return
+		}
+		resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("config"), data.Config)...)
+		resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("config_contents"), data.ConfigContents)...)
+		resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("config_dir"), data.ConfigDir)...)
+	} else if _, _, _, err := parseImportID(req.ID); err != nil {
+		resp.Diagnostics.AddError("Unable to import build", err.Error())
+		return
+	}
+	// Read replaces this with the build's fingerprint, once it has a
+	// configuration. Until then, it's how Read finds the packages.
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), req.ID)...)
+}
+
+// readBuilt updates the build's apks, sboms, provenance and effective_archs
+// from the packages in its dir, leaving out the archs whose package is
+// missing. It reports whether the build still has any. A build with no
+// configuration, imported by name, is found by its ID instead.
+func (r *BuildResource) readBuilt(ctx context.Context, data *BuildResourceModel) (bool, error) {
+	var (
+		apks  builtAPKs
+		archs []apkotypes.Architecture
+		err   error
+	)
+	if data.ConfigContents.IsNull() {
+		name, version, epoch, err := parseImportID(data.Id.ValueString())
+		if err != nil {
+			// Imported by name by an earlier version of the provider, which
+			// replaced the ID, so there's nothing to find the packages by.
+			return true, nil
+		}
+		if apks, archs, err = r.findAPKs(name, version, epoch); err != nil {
+			return false, err
+		}
+	} else {
+		if apks, err = r.apks(*data); err != nil {
+			return false, err
+		}
+		if data.EffectiveArchs.IsNull() {
+			// Imported by its configuration.
+			if archs, err = r.archs(ctx, *data); err != nil {
+				return false, err
+			}
+		} else {
+			var names []string
+			if diags := data.EffectiveArchs.ElementsAs(ctx, &names, false); diags.HasError() {
+				return false, fmt.Errorf("reading effective_archs: %v", diags.Errors())
+			}
+			for _, a := range names {
+				archs = append(archs, apkotypes.ParseArchitecture(a))
+			}
+		}
+	}
+
+	// Only keep the archs the package was built for. The others are built
+	// on the next apply.
+	var built []apkotypes.Architecture
+	for _, arch := range archs {
+		if fileExists(apks.path(arch, apks.names[0])) {
+			built = append(built, arch)
+		}
+	}
+	if len(built) == 0 {
+		return false, nil
+	}
+
+	if data.APKs, err = apks.outputs(built); err != nil {
+		return false, fmt.Errorf("reading packages: %w", err)
+	}
+	if data.SBOMs, err = apks.sboms(built); err != nil {
+		return false, fmt.Errorf("reading SBOM: %w", err)
+	}
+	if data.Provenance, err = apks.provenance(built); err != nil {
+		return false, fmt.Errorf("reading provenance: %w", err)
+	}
+	data.EffectiveArchs = archsValue(built)
+	return true, nil
+}
+
+// importConfig reads the melange configuration at path into the attributes
+// melange_config would set from config_file, with no architectures
+// overridden.
+func (r *BuildResource) importConfig(ctx context.Context, path string) (BuildResourceModel, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return BuildResourceModel{}, fmt.Errorf("resolving %s: %w", path, err)
+	}
+	contents, err := os.ReadFile(abs)
+	if err != nil {
+		return BuildResourceModel{}, err
+	}
+	var cfg Configuration
+	if err := yaml.Unmarshal(contents, &cfg); err != nil {
+		return BuildResourceModel{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	targets, err := targetArchitectures(contents)
+	if err != nil {
+		return BuildResourceModel{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	configArchs := make([]string, 0, len(cfg.Environment.Archs))
+	for _, a := range cfg.Environment.Archs {
+		configArchs = append(configArchs, a.String())
+	}
+	archs, err := effectiveArchs(targets, nil, configArchs, r.popts.archs)
+	if err != nil {
+		return BuildResourceModel{}, err
+	}
+	parsed, err := parseMelangeConfig(contents)
+	if err != nil {
+		return BuildResourceModel{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	config, diags := r.popts.configValue(ctx, cfg, parsed, archs)
+	if diags.HasError() {
+		return BuildResourceModel{}, fmt.Errorf("converting %s: %v", path, diags.Errors())
+	}
+	return BuildResourceModel{
+		Config:         config,
+		ConfigContents: types.StringValue(string(contents)),
+		ConfigDir:      types.StringValue(filepath.Dir(abs)),
+		Archs:          types.ListNull(types.StringType),
+	}, nil
+}
+
+// parseImportID parses an import ID of the form name-version-rEPOCH. Package
+// names may contain dashes, but versions may not.
+func parseImportID(id string) (string, string, uint64, error) {
+	invalid := fmt.Errorf("import ID %q must be name-version-rEPOCH, or the path to a melange configuration ending in .yaml", id)
+	rest, r, ok := cutLast(id, "-")
+	if !ok || !strings.HasPrefix(r, "r") {
+		return "", "", 0, invalid
+	}
+	epoch, err := strconv.ParseUint(strings.TrimPrefix(r, "r"), 10, 64)
+	if err != nil {
+		return "", "", 0, invalid
+	}
+	name, version, ok := cutLast(rest, "-")
+	if !ok || name == "" || version == "" {
+		return "", "", 0, invalid
+	}
+	return name, version, epoch, nil
+}
+
+func cutLast(s, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+// findAPKs finds the archs the package was built for in the provider's dir,
+// and its subpackages, the APKs of the same version whose origin is the
+// package.
+func (r *BuildResource) findAPKs(name, version string, epoch uint64) (builtAPKs, []apkotypes.Architecture, error) {
+	apks := builtAPKs{dir: filepath.Join(r.popts.dir, "packages"), version: version, epoch: epoch, names: []string{name}}
+	suffix := fmt.Sprintf("-%s-r%d.apk", version, epoch)
+	archDirs, err := os.ReadDir(apks.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return builtAPKs{}, nil, err
+	}
+
+	var archs []apkotypes.Architecture
+	subpackages := map[string]bool{}
+	for _, d := range archDirs {
+		if !d.IsDir() {
+			continue
+		}
+		arch := apkotypes.ParseArchitecture(d.Name())
+		if !fileExists(apks.path(arch, name)) {
+			continue
+		}
+		archs = append(archs, arch)
+
+		matches, err := filepath.Glob(filepath.Join(apks.dir, d.Name(), "*"+suffix))
+		if err != nil {
+			return builtAPKs{}, nil, err
+		}
+		for _, m := range matches {
+			sp := strings.TrimSuffix(filepath.Base(m), suffix)
+			if sp == name || subpackages[sp] {
+				continue
+			}
+			info, err := readPkgInfo(m)
+			if err != nil {
+				return builtAPKs{}, nil, err
+			}
+			if info["origin"] == name {
+				subpackages[sp] = true
+			}
+		}
+	}
+	for sp := range subpackages {
+		apks.names = append(apks.names, sp)
+	}
+	sort.Strings(apks.names[1:])
+	return apks, archs, nil
+}
@@ -0,0 +1,46 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseImportID(t *testing.T) {
+	for _, c := range []struct {
+		id, name, version string
+		epoch             uint64
+		wantErr           bool
+	}{
+		{id: "hello-1.2.3-r4", name: "hello", version: "1.2.3", epoch: 4},
+		{id: "py3-hello-world-0.1_rc1-r0", name: "py3-hello-world", version: "0.1_rc1", epoch: 0},
+		{id: "hello-1.2.3", wantErr: true},
+		{id: "hello-r1", wantErr: true},
+		{id: "-1.2.3-r1", wantErr: true},
+		{id: "hello-1.2.3-rx", wantErr: true},
+	} {
+		name, version, epoch, err := parseImportID(c.id)
+		switch {
+		case c.wantErr && err == nil:
+			t.Errorf("parseImportID(%q) = %q, %q, %d, want error", c.id, name, version, epoch)
+		case !c.wantErr && err != nil:
+			t.Errorf("parseImportID(%q): %v", c.id, err)
+		case !c.wantErr && (name != c.name || version != c.version || epoch != c.epoch):
+			t.Errorf("parseImportID(%q) = %q, %q, %d, want %q, %q, %d", c.id, name, version, epoch, c.name, c.version, c.epoch)
+		}
+	}
+}
+
+func TestReadPkgInfo(t *testing.T) {
+	apk := filepath.Join(t.TempDir(), "test-1.0.0-r0.apk")
+	writeTestAPK(t, apk, []testFile{{name: "usr/bin/test", contents: "test", mode: 0o755}})
+	info, err := readPkgInfo(apk)
+	if err != nil {
+		t.Fatalf("readPkgInfo: %v", err)
+	}
+	if info["pkgname"] != "test" || info["datahash"] == "" {
+		t.Errorf("got %v, want pkgname and datahash", info)
+	}
+}
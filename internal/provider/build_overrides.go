@@ -0,0 +1,211 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// buildOverrides are the provider options melange_build and melange_test can
+// override.
+type buildOverrides struct {
+	extraRepositories, extraKeyring    types.List
+	dir, signingKey, runner, namespace types.String
+}
+
+// withOverrides returns a copy of the options overridden by a resource's own
+// settings. Repositories and keys are added to the provider's; everything
+// else replaces it.
+func (o ProviderOpts) withOverrides(ctx context.Context, ov buildOverrides) (ProviderOpts, error) {
+	var repos, keys []string
+	if diags := ov.extraRepositories.ElementsAs(ctx, &repos, false); diags.HasError() {
+		return o, fmt.Errorf("reading extra_repositories: %v", diags.Errors())
+	}
+	if diags := ov.extraKeyring.ElementsAs(ctx, &keys, false); diags.HasError() {
+		return o, fmt.Errorf("reading extra_keyring: %v", diags.Errors())
+	}
+	o.repositories = append(append([]string(nil), o.repositories...), repos...)
+	o.keyring = append(append([]string(nil), o.keyring...), keys...)
+	if v := ov.dir.ValueString(); v != "" {
+		o.dir = v
+	}
+	if v := ov.signingKey.ValueString(); v != "" {
+		o.signingKey = v
+	}
+	if v := ov.namespace.ValueString(); v != "" {
+		o.namespace = v
+	}
+	if v := ov.runner.ValueString(); v != "" {
+		if !validRunner(v) {
+			return o, fmt.Errorf("unsupported runner %q; supported runners are: %s", v, strings.Join(runnerNames, ", "))
+		}
+		o.runner = v
+	}
+	return o, nil
+}
+
+// withOverrides returns a copy of the resource whose provider options are
+// overridden by the build's own settings, and whose logs don't contain the
+// values of its sensitive_env.
+func (r *BuildResource) withOverrides(ctx context.Context, data BuildResourceModel) (*BuildResource, error) {
+	o, err := r.popts.withOverrides(ctx, buildOverrides{
+		extraRepositories: data.ExtraRepositories,
+		extraKeyring:      data.ExtraKeyring,
+		dir:               data.Dir,
+		signingKey:        data.SigningKey,
+		runner:            data.Runner,
+		namespace:         data.Namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := sensitiveValues(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	o.secrets = append(append([]string(nil), o.secrets...), secrets...)
+	return &BuildResource{popts: o}, nil
+}
+
+// localPipelines returns the directory to load local pipelines from: the
+// default pipelines directory for configDir, or if pipeline_dirs are set, a
+// directory under scratch merging them with it.
+func (o ProviderOpts) localPipelines(ctx context.Context, configDir string, pipelineDirs types.List, scratch string) (string, error) {
+	pipelineDir := o.pipelineDir(configDir)
+	var dirs []string
+	if diags := pipelineDirs.ElementsAs(ctx, &dirs, false); diags.HasError() {
+		return "", fmt.Errorf("reading pipeline_dirs: %v", diags.Errors())
+	}
+	if len(dirs) == 0 {
+		return pipelineDir, nil
+	}
+	merged := filepath.Join(scratch, "pipelines")
+	if err := mergePipelineDirs(merged, append(dirs, pipelineDir)); err != nil {
+		return "", err
+	}
+	return merged, nil
+}
+
+// mergePipelineDirs creates the directory merged, containing the pipelines
+// in each of dirs, linked to from the first directory that has them.
+func mergePipelineDirs(merged string, dirs []string) error {
+	if err := os.MkdirAll(merged, 0o755); err != nil {
+		return fmt.Errorf("creating pipeline directory: %w", err)
+	}
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(abs); os.IsNotExist(err) {
+			continue
+		}
+		if err := filepath.WalkDir(abs, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || filepath.Ext(path) != ".yaml" {
+				return err
+			}
+			rel, err := filepath.Rel(abs, path)
+			if err != nil {
+				return err
+			}
+			link := filepath.Join(merged, rel)
+			if _, err := os.Lstat(link); err == nil {
+				// An earlier directory has this pipeline.
+				return nil
+			}
+			if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+				return err
+			}
+			return os.Symlink(path, link)
+		}); err != nil {
+			return fmt.Errorf("reading pipelines from %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// withVars returns the configuration with its `vars` overridden by vars.
+func withVars(contents []byte, vars map[string]string) ([]byte, error) {
+	if len(vars) == 0 {
+		return contents, nil
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("configuration isn't a mapping")
+	}
+	doc := root.Content[0]
+
+	var section *yaml.Node
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value == "vars" {
+			section = doc.Content[i+1]
+			break
+		}
+	}
+	if section == nil {
+		section = &yaml.Node{}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "vars"}, section)
+	}
+	if section.Kind != yaml.MappingNode {
+		*section = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: vars[k]}
+		found := false
+		for i := 0; i+1 < len(section.Content); i += 2 {
+			if section.Content[i].Value == k {
+				section.Content[i+1] = value
+				found = true
+			}
+		}
+		if !found {
+			section.Content = append(section.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: k}, value)
+		}
+	}
+	return yaml.Marshal(&root)
+}
+
+// writeEnvFile writes an environment file for melange to path, with the
+// variables in base, if any, overlaid with env. Only the owner can read it,
+// since env may contain secrets.
+func writeEnvFile(path, base string, env map[string]string) error {
+	merged := map[string]string{}
+	if base != "" {
+		var err error
+		if merged, err = godotenv.Read(base); err != nil {
+			return fmt.Errorf("reading env file: %w", err)
+		}
+	}
+	for k, v := range env {
+		merged[k] = v
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating env file: %w", err)
+	}
+	f.Close()
+	if err := godotenv.Write(merged, path); err != nil {
+		return fmt.Errorf("writing env file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWithVars(t *testing.T) {
+	for _, c := range []struct {
+		desc, config string
+		want         map[string]string
+	}{{
+		desc:   "override and add",
+		config: "package:\n  name: foo\nvars:\n  a: one\n  b: two\n",
+		want:   map[string]string{"a": "1", "b": "two", "c": "3"},
+	}, {
+		desc:   "no vars",
+		config: "package:\n  name: foo\n",
+		want:   map[string]string{"a": "1", "c": "3"},
+	}, {
+		desc:   "empty vars",
+		config: "package:\n  name: foo\nvars:\n",
+		want:   map[string]string{"a": "1", "c": "3"},
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			out, err := withVars([]byte(c.config), map[string]string{"a": "1", "c": "3"})
+			if err != nil {
+				t.Fatalf("withVars: %v", err)
+			}
+			var got struct {
+				Vars map[string]string `yaml:"vars"`
+			}
+			if err := yaml.Unmarshal(out, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Vars, c.want) {
+				t.Errorf("got vars %v, want %v", got.Vars, c.want)
+			}
+		})
+	}
+}
+
+func TestMergePipelineDirs(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	write := func(dir, name, contents string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(first, "greet.yaml", "first")
+	write(second, "greet.yaml", "second")
+	write(second, "go/build.yaml", "second")
+
+	merged := filepath.Join(t.TempDir(), "pipelines")
+	if err := mergePipelineDirs(merged, []string{first, second, filepath.Join(first, "missing")}); err != nil {
+		t.Fatalf("mergePipelineDirs: %v", err)
+	}
+	for name, want := range map[string]string{"greet.yaml": "first", "go/build.yaml": "second"} {
+		if b, err := os.ReadFile(filepath.Join(merged, name)); err != nil || string(b) != want {
+			t.Errorf("%s: got %q, %v; want %q", name, b, err, want)
+		}
+	}
+}
+
+func TestWriteEnvFile(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "build.env")
+	if err := os.WriteFile(base, []byte("A=1\nB=2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(filepath.Dir(base), "merged.env")
+	if err := writeEnvFile(path, base, map[string]string{"B": "two words", "C": "line\nbreak"}); err != nil {
+		t.Fatalf("writeEnvFile: %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("got env file %v, %v; want it readable only by its owner", fi, err)
+	}
+	got, err := godotenv.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"A": "1", "B": "two words", "C": "line\nbreak"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	apkrepo "gitlab.alpinelinux.org/alpine/go/repository"
+)
+
+// The actions a build takes for each arch, reported by planned_actions.
+const (
+	actionBuild   = "build"   // the package hasn't been built
+	actionRebuild = "rebuild" // the package was built with a different fingerprint
+	actionForce   = "force"   // force_update is set
+	actionSkip    = "skip"    // the package is up to date
+)
+
+// archAction returns what building apks for arch with fingerprint fp does.
+// Packages whose subpackages are missing, or missing from the index, are
+// rebuilt.
+func archAction(apks builtAPKs, arch apkotypes.Architecture, fp, srcHash string, force bool) string {
+	apkPath := apks.path(arch, apks.names[0])
+	switch {
+	case force:
+		return actionForce
+	case !fileExists(apkPath):
+		return actionBuild
+	case upToDate(apkPath, fp, srcHash) && len(apks.missing(arch)) == 0:
+		return actionSkip
+	default:
+		return actionRebuild
+	}
+}
+
+// missing returns the APKs of the package and its subpackages built for arch
+// that are missing, or missing from the index. Subpackages with an if
+// condition aren't always built, so they're only missing if the index lists
+// them.
+func (b builtAPKs) missing(arch apkotypes.Architecture) []string {
+	indexed := indexedPackages(filepath.Join(b.dir, arch.ToAPK()))
+	version := fmt.Sprintf("%s-r%d", b.version, b.epoch)
+	var missing []string
+	for _, name := range b.names {
+		apk := b.path(arch, name)
+		exists, listed := fileExists(apk), indexed[name+"-"+version]
+		switch {
+		case exists && !listed:
+			missing = append(missing, fmt.Sprintf("the index entry of %s", apk))
+		case !exists && (listed || !b.conditional[name]):
+			missing = append(missing, apk)
+		}
+	}
+	return missing
+}
+
+// indexedPackages returns the name-version of each package in the index in
+// dir. An index that doesn't exist or can't be read lists none.
+func indexedPackages(dir string) map[string]bool {
+	f, err := os.Open(filepath.Join(dir, "APKINDEX.tar.gz"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	idx, err := apkrepo.IndexFromArchive(f)
+	if err != nil {
+		return nil
+	}
+	indexed := make(map[string]bool, len(idx.Packages))
+	for _, p := range idx.Packages {
+		indexed[p.Name+"-"+p.Version] = true
+	}
+	return indexed
+}
+
+// planned returns what applying the build does: the action for each arch,
+// and the APKs the package and its subpackages are expected to produce.
+// data.Id and data.SourceHash must be those of the planned build.
+func (r *BuildResource) planned(data BuildResourceModel, archs []apkotypes.Architecture) (types.Map, types.List, error) {
+	apks, err := r.apks(data)
+	if err != nil {
+		return types.Map{}, types.List{}, err
+	}
+	actions := make(map[string]attr.Value, len(archs))
+	var paths []attr.Value
+	for _, arch := range archs {
+		action := archAction(apks, arch, data.Id.ValueString(), data.SourceHash.ValueString(), data.ForceUpdate.ValueBool())
+		actions[arch.ToAPK()] = types.StringValue(action)
+		for _, name := range apks.names {
+			paths = append(paths, types.StringValue(apks.path(arch, name)))
+		}
+	}
+	return types.MapValueMust(types.StringType, actions), types.ListValueMust(types.StringType, paths), nil
+}
+
+// plansBuild reports whether any of the planned actions builds the package.
+func plansBuild(actions types.Map) bool {
+	for _, a := range actions.Elements() {
+		if a.(types.String).ValueString() != actionSkip {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePlanned fills in what applying the build does, if it wasn't known
+// when the build was planned, before anything is built. priorID is the ID of
+// the build being updated, if any.
+func (r *BuildResource) resolvePlanned(ctx context.Context, data *BuildResourceModel, archs []apkotypes.Architecture, priorID string) error {
+	if !data.PlannedActions.IsUnknown() && !data.PlannedAPKs.IsUnknown() && !data.FingerprintChanged.IsUnknown() {
+		return nil
+	}
+	planned := *data
+	_, hash, err := r.sourceHash(planned)
+	if err != nil {
+		return err
+	}
+	planned.SourceHash = types.StringValue(hash)
+	id, err := fingerprint(ctx, planned)
+	if err != nil {
+		return err
+	}
+	planned.Id = types.StringValue(id)
+	actions, apks, err := r.planned(planned, archs)
+	if err != nil {
+		return err
+	}
+	data.PlannedActions, data.PlannedAPKs = actions, apks
+	data.FingerprintChanged = types.BoolValue(id != priorID)
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	apkrepo "gitlab.alpinelinux.org/alpine/go/repository"
+)
+
+func TestArchAction(t *testing.T) {
+	arch := apkotypes.ParseArchitecture("amd64")
+	apks := builtAPKs{
+		dir:         t.TempDir(),
+		version:     "1.0.0",
+		names:       []string{"hello", "hello-doc", "hello-extra"},
+		conditional: map[string]bool{"hello-extra": true},
+	}
+	apk, doc := apks.path(arch, "hello"), apks.path(arch, "hello-doc")
+	if got := archAction(apks, arch, "fp", "", false); got != actionBuild {
+		t.Errorf("missing package: got %q, want %q", got, actionBuild)
+	}
+
+	for _, path := range []string{apk, doc} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestAPK(t, path, []testFile{{name: "usr/bin/hello", contents: "hello", mode: 0o755}})
+	}
+	p := buildProvenance{arch: arch, fingerprint: "fp"}
+	if err := p.write(apk, nil); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got, want := apks.missing(arch), []string{"the index entry of " + apk, "the index entry of " + doc}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unindexed packages: missing = %v, want %v", got, want)
+	}
+	if got := archAction(apks, arch, "fp", "", false); got != actionRebuild {
+		t.Errorf("unindexed packages: got %q, want %q", got, actionRebuild)
+	}
+
+	writeTestIndex(t, filepath.Dir(apk), "1.0.0-r0", "hello", "hello-doc")
+	for _, c := range []struct {
+		fp    string
+		force bool
+		want  string
+	}{
+		{fp: "fp", want: actionSkip},
+		{fp: "other", want: actionRebuild},
+		{fp: "fp", force: true, want: actionForce},
+	} {
+		if got := archAction(apks, arch, c.fp, "", c.force); got != c.want {
+			t.Errorf("archAction(fingerprint %q, force %t) = %q, want %q", c.fp, c.force, got, c.want)
+		}
+	}
+
+	if err := os.Remove(doc); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := apks.missing(arch), []string{doc}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missing subpackage: missing = %v, want %v", got, want)
+	}
+	if got := archAction(apks, arch, "fp", "", false); got != actionRebuild {
+		t.Errorf("missing subpackage: got %q, want %q", got, actionRebuild)
+	}
+
+	// Conditional subpackages are only expected once they're indexed.
+	writeTestAPK(t, doc, []testFile{{name: "usr/bin/hello", contents: "hello", mode: 0o755}})
+	writeTestIndex(t, filepath.Dir(apk), "1.0.0-r0", "hello", "hello-doc", "hello-extra")
+	if got, want := apks.missing(arch), []string{apks.path(arch, "hello-extra")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missing conditional subpackage: missing = %v, want %v", got, want)
+	}
+}
+
+// writeTestIndex writes an index to dir listing the packages named names at
+// version.
+func writeTestIndex(t *testing.T, dir, version string, names ...string) {
+	t.Helper()
+	var idx apkrepo.ApkIndex
+	for _, name := range names {
+		idx.Packages = append(idx.Packages, &apkrepo.Package{Name: name, Version: version})
+	}
+	archive, err := apkrepo.ArchiveFromIndex(&idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "APKINDEX.tar.gz"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,27 +5,33 @@ package provider
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	apkotypes "chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/melange/pkg/build"
-	"github.com/chainguard-dev/terraform-provider-apko/reflect"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
-	"golang.org/x/sync/errgroup"
 )
 
 // Ensure provider defined types fully satisfy framework interfaces.
 var _ resource.Resource = &BuildResource{}
 var _ resource.ResourceWithImportState = &BuildResource{}
+var _ resource.ResourceWithModifyPlan = &BuildResource{}
 
 func NewBuildResource() resource.Resource {
 	return &BuildResource{}
@@ -38,10 +44,39 @@ type BuildResource struct {
 
 // BuildResourceModel describes the resource data model.
 type BuildResourceModel struct {
-	Config         types.Object `tfsdk:"config"`
-	ConfigContents types.String `tfsdk:"config_contents"`
-	Id             types.String `tfsdk:"id"`
-	ForceUpdate    types.Bool   `tfsdk:"force_update"`
+	Config             types.Object `tfsdk:"config"`
+	ConfigContents     types.String `tfsdk:"config_contents"`
+	ConfigDir          types.String `tfsdk:"config_dir"`
+	Archs              types.List   `tfsdk:"archs"`
+	EffectiveArchs     types.List   `tfsdk:"effective_archs"`
+	APKs               types.List   `tfsdk:"apks"`
+	SBOMs              types.List   `tfsdk:"sboms"`
+	Provenance         types.List   `tfsdk:"provenance"`
+	PlannedActions     types.Map    `tfsdk:"planned_actions"`
+	PlannedAPKs        types.List   `tfsdk:"planned_apks"`
+	FingerprintChanged types.Bool   `tfsdk:"fingerprint_changed"`
+	Id                 types.String `tfsdk:"id"`
+	ForceUpdate        types.Bool   `tfsdk:"force_update"`
+	VerifyReproducible types.Bool   `tfsdk:"verify_reproducible"`
+	KeepWorkspace      types.Bool   `tfsdk:"keep_workspace"`
+	RetainOnDelete     types.Bool   `tfsdk:"retain_on_delete"`
+	SignProvenance     types.Bool   `tfsdk:"sign_provenance"`
+	ExtraRepositories  types.List   `tfsdk:"extra_repositories"`
+	ExtraKeyring       types.List   `tfsdk:"extra_keyring"`
+	Dir                types.String `tfsdk:"dir"`
+	SigningKey         types.String `tfsdk:"signing_key"`
+	Runner             types.String `tfsdk:"runner"`
+	Namespace          types.String `tfsdk:"namespace"`
+	Env                types.Map    `tfsdk:"env"`
+	EnvByArch          types.Map    `tfsdk:"env_by_arch"`
+	SensitiveEnv       types.Map    `tfsdk:"sensitive_env"`
+	EnvFile            types.String `tfsdk:"env_file"`
+	SourceDir          types.String `tfsdk:"source_dir"`
+	SourceHash         types.String `tfsdk:"source_hash"`
+	PipelineDirs       types.List   `tfsdk:"pipeline_dirs"`
+	Vars               types.Map    `tfsdk:"vars"`
+	Retry              types.Object `tfsdk:"retry"`
+	Timeouts           types.Object `tfsdk:"timeouts"`
 }
 
 func (r *BuildResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -50,29 +85,156 @@ func (r *BuildResource) Metadata(ctx context.Context, req resource.MetadataReque
 
 func (r *BuildResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
+		Version: buildSchemaVersion,
 		// This description is used by the documentation generator and the language server.
 		MarkdownDescription: "Example resource",
 
 		Attributes: map[string]schema.Attribute{
-			"config": schema.ObjectAttribute{
-				MarkdownDescription: "Parsed melange config",
+			"config": schema.SingleNestedAttribute{
+				MarkdownDescription: "Parsed melange config, usually `config` from `melange_config`.",
 				Required:            true,
-				AttributeTypes:      configSchema.AttrTypes,
+				Attributes:          resourceConfigAttributes(configAttributes),
 			},
 			"config_contents": schema.StringAttribute{
-				MarkdownDescription: "The raw contents of the melange configuration.",
+				MarkdownDescription: "The raw contents of the melange configuration. `yamlencode(config)` isn't a melange configuration, since `config` only summarizes the package and its build environment with Terraform's attribute names, so edit the configuration's YAML instead, e.g. with `replace` or `templatefile`.",
 				Required:            true,
 			},
+			"config_dir": schema.StringAttribute{
+				MarkdownDescription: "The directory containing the melange configuration, usually `config_dir` from `melange_config`. If set, local pipelines are resolved relative to it instead of the provider's `dir`, and a relative `source_dir` is resolved relative to it instead of the working directory.",
+				Optional:            true,
+			},
+			"archs": schema.ListAttribute{
+				MarkdownDescription: "Architectures to build for, overriding `config.environment.archs` and the provider's `default_archs`. Architectures not in the configuration's `target-architecture` are ignored.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"effective_archs": schema.ListAttribute{
+				MarkdownDescription: "The architectures the package is built for.",
+				Computed:            true,
+				ElementType:         types.StringType,
+			},
+			"apks": schema.ListAttribute{
+				MarkdownDescription: "The APKs of the package and each subpackage built for each architecture, with the `path` and `sha256` digest of each. Subpackages that weren't built aren't listed.",
+				Computed:            true,
+				ElementType:         builtAPKType,
+			},
+			"sboms": schema.ListAttribute{
+				MarkdownDescription: "The SPDX SBOM of the package and each subpackage for each architecture, extracted from the APK and written next to it. Each has the `path` of the SBOM, its `packages`, and the distinct `licenses` and source `download_locations` they declare.",
+				Computed:            true,
+				ElementType:         sbomType,
+			},
+			"provenance": schema.ListAttribute{
+				MarkdownDescription: "The in-toto SLSA v1 provenance `statement` of the package and each subpackage for each architecture, written to an `.intoto.json` file next to the APK. It lists the digests of the configuration and its fetched sources, the builder image, the runner, and the packages installed in the build environment. Packages that weren't rebuilt keep the provenance of the build that produced them.",
+				Computed:            true,
+				ElementType:         provenanceType,
+			},
+			"planned_actions": schema.MapAttribute{
+				MarkdownDescription: "What the last plan said applying the build would do for each architecture: `build` a package that doesn't exist, `rebuild` one built with a different fingerprint or missing some of its subpackages or index entries, `force` a rebuild because `force_update` is set, or `skip` one that's up to date. It's unchanged by plans that don't change the build.",
+				Computed:            true,
+				ElementType:         types.StringType,
+			},
+			"planned_apks": schema.ListAttribute{
+				MarkdownDescription: "The paths of the APKs the package and its subpackages are expected to produce for each architecture. Subpackages that don't install any files don't produce one.",
+				Computed:            true,
+				ElementType:         types.StringType,
+			},
+			"fingerprint_changed": schema.BoolAttribute{
+				MarkdownDescription: "Whether the last plan changed the build's fingerprint, the `id`.",
+				Computed:            true,
+			},
 			"force_update": schema.BoolAttribute{
 				MarkdownDescription: "Force a rebuild of the package, even if it already exists.",
 				Optional:            true,
 			},
+			"verify_reproducible": schema.BoolAttribute{
+				MarkdownDescription: "Build each architecture a second time in a separate output directory, and fail if the data sections of the resulting packages differ, listing the files whose content, mode or mtime differ. Both builds use a fixed `SOURCE_DATE_EPOCH` of 0, unless one is set in the environment. Packages that are already built aren't rebuilt, but are still built a second time and compared, with the `SOURCE_DATE_EPOCH` they were built with.",
+				Optional:            true,
+			},
+			"keep_workspace": schema.BoolAttribute{
+				MarkdownDescription: "Keep the build's scratch directory in `.melange-scratch` under `dir` instead of removing it once the build is done. It holds the configuration, environment files and pipelines passed to melange, and the workspace of each failed build, which is useful for debugging. Environment files include the values of `sensitive_env`. The guest directory of a failed build is kept where melange created it, and both are named in the error.",
+				Optional:            true,
+			},
+			"retain_on_delete": schema.BoolAttribute{
+				MarkdownDescription: "Leave the packages in `dir` when the build is destroyed or replaced. Otherwise the APKs of the package and its subpackages, with their SBOMs, provenance and logs, are removed along with their entries in the index. Changing the package's name or version replaces the build, so old versions are removed unless this is set. Changing its epoch rebuilds it in place and removes the previous epoch's packages, unless this or the provider's `retain_versions` is set. Like other settings, it takes effect once applied, so set it before changing the version.",
+				Optional:            true,
+			},
+			"sign_provenance": schema.BoolAttribute{
+				MarkdownDescription: "Sign provenance statements with the provider's `signing_key`, the key used to sign the APKs, writing them as DSSE envelopes. They're signed with RSA PKCS #1 v1.5 over SHA-256, which in-toto and cosign verify, unlike the SHA-1 signatures of APKs.",
+				Optional:            true,
+			},
+			"extra_repositories": schema.ListAttribute{
+				MarkdownDescription: "Additional repositories to search for packages, added to the provider's.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"extra_keyring": schema.ListAttribute{
+				MarkdownDescription: "Additional keys to use for package verification, added to the provider's.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"dir": schema.StringAttribute{
+				MarkdownDescription: "Directory to use for building the package, overriding the provider's `dir`.",
+				Optional:            true,
+			},
+			"signing_key": schema.StringAttribute{
+				MarkdownDescription: "The path to the RSA private key used to sign the package, relative to `dir`, overriding the provider's `signing_key`.",
+				Optional:            true,
+			},
+			"runner": schema.StringAttribute{
+				MarkdownDescription: "The runner to build the package with, overriding the provider's `runner`.",
+				Optional:            true,
+			},
+			"namespace": schema.StringAttribute{
+				MarkdownDescription: "The namespace to use for the package, overriding the provider's `namespace`.",
+				Optional:            true,
+			},
+			"env": schema.MapAttribute{
+				MarkdownDescription: "Environment variables to set in the build, overlaid on `env_file`. Variables set in the configuration's `environment` take precedence. The package is rebuilt when they change.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"env_by_arch": schema.MapAttribute{
+				MarkdownDescription: "Environment variables to set in the build for each architecture, keyed by architecture, like `x86_64` or `aarch64`, and overlaid on `env`.",
+				Optional:            true,
+				ElementType:         types.MapType{ElemType: types.StringType},
+			},
+			"sensitive_env": schema.MapAttribute{
+				MarkdownDescription: "Environment variables to set in the build from the provider's environment, overlaid on `env` and `env_by_arch`: each is the name of an environment variable of the provider, like `{ TOKEN = \"GITHUB_TOKEN\" }`. Only the names are stored in the state and included in the fingerprint, so changing a value doesn't rebuild the package. The values are read when the package is built, which fails if they aren't set, and replaced with `<redacted>` in build logs.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"env_file": schema.StringAttribute{
+				MarkdownDescription: "An environment file to load into the build. If it isn't set, `build-<arch>.env` in `dir` is loaded if it exists, but that's deprecated in favor of `env_file`, `env` and `env_by_arch`.",
+				Optional:            true,
+			},
+			"source_dir": schema.StringAttribute{
+				MarkdownDescription: "The directory to copy into the build's workspace. A relative path is resolved against `config_dir`, if that's set. Without `source_dir`, the directory named after the package in `dir` is copied if it exists, or else the working directory, but both are deprecated: the next major release starts the build with an empty workspace instead.",
+				Optional:            true,
+			},
+			"source_hash": schema.StringAttribute{
+				MarkdownDescription: "The sha256 digest of the files copied from the source directory into the build's workspace, which are those not matched by its `.melangeignore`. The package is rebuilt when it changes.",
+				Computed:            true,
+			},
+			"pipeline_dirs": schema.ListAttribute{
+				MarkdownDescription: "Directories to load local pipelines from, searched in order before the default pipelines directory.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"vars": schema.MapAttribute{
+				MarkdownDescription: "Values to set in the configuration's `vars`, overriding those in the configuration.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
 			"id": schema.StringAttribute{
 				Computed:            true,
-				MarkdownDescription: "Identifier of the resource",
+				MarkdownDescription: "The build's fingerprint, the sha256 digest of the configuration it builds, with `vars` applied and without its `test` section, `source_hash` and environment variables, apart from the values of `sensitive_env`. Packages built with a different fingerprint are rebuilt. Builds imported by name keep their import ID until they're applied with a configuration.",
 				PlanModifiers:       []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"retry":    retryBlock,
+			"timeouts": timeoutsBlock("create", "update"),
+		},
 	}
 }
 
@@ -97,19 +259,77 @@ func (r *BuildResource) Create(ctx context.Context, req resource.CreateRequest,
 		return
 	}
 
-	if err := r.doBuild(ctx, data); err != nil {
+	// Build with this resource's overrides of the provider's options.
+	r, err := r.withOverrides(ctx, data)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	archs, err := r.archs(ctx, data)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	data.EffectiveArchs = archsValue(archs)
+	if err := r.resolvePlanned(ctx, &data, archs, ""); err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+
+	bctx, cancel, limit, err := withTimeout(ctx, data.Timeouts, "create")
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("timeouts").AtName("create"), "Invalid timeout", err.Error())
+		return
+	}
+	defer cancel()
+	if err := r.doBuild(bctx, data, archs); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			resp.Diagnostics.AddError("Build timed out", fmt.Sprintf("Builds did not complete within %s:\n%v", limit, err))
+			return
+		}
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+
+	apks, err := r.apks(data)
+	if err != nil {
 		resp.Diagnostics.AddError("Client Error", err.Error())
 		return
 	}
+	outputs, err := apks.outputs(archs)
+	if err != nil {
+		resp.Diagnostics.AddError("Error reading packages", err.Error())
+		return
+	}
+	data.APKs = outputs
+
+	sboms, err := apks.sboms(archs)
+	if err != nil {
+		resp.Diagnostics.AddError("Error reading SBOM", err.Error())
+		return
+	}
+	data.SBOMs = sboms
+
+	provenance, err := apks.provenance(archs)
+	if err != nil {
+		resp.Diagnostics.AddError("Error reading provenance", err.Error())
+		return
+	}
+	data.Provenance = provenance
+
+	_, hash, err := r.sourceHash(data)
+	if err != nil {
+		resp.Diagnostics.AddError("Error hashing source directory", err.Error())
+		return
+	}
+	data.SourceHash = types.StringValue(hash)
 
-	// ID is the sha256 of the JSON-serialized input config,
-	// to ensure the resource is updated if the changes.
-	b, err := json.Marshal(data.Config.String())
+	id, err := fingerprint(ctx, data)
 	if err != nil {
 		resp.Diagnostics.AddError("Client Error", err.Error())
 		return
 	}
-	data.Id = types.StringValue(fmt.Sprintf("%x", sha256.Sum256(b)))
+	data.Id = types.StringValue(id)
 
 	// Save data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
@@ -122,14 +342,33 @@ func (r *BuildResource) Read(ctx context.Context, req resource.ReadRequest, resp
 		return
 	}
 
-	// ID is the sha256 of the JSON-serialized input config,
-	// to ensure the resource is updated if the changes.
-	b, err := json.Marshal(data.Config.String())
+	// Only the build's dir is needed to find its packages, so its other
+	// overrides, such as sensitive_env, aren't resolved.
+	found := &BuildResource{popts: r.popts}
+	if v := data.Dir.ValueString(); v != "" {
+		found.popts.dir = v
+	}
+	ok, err := found.readBuilt(ctx, &data)
 	if err != nil {
-		resp.Diagnostics.AddError("Client Error", err.Error())
+		resp.Diagnostics.AddError("Error reading packages", err.Error())
+		return
+	}
+	if !ok {
+		// The packages were removed outside of Terraform.
+		resp.State.RemoveResource(ctx)
 		return
 	}
-	data.Id = types.StringValue(fmt.Sprintf("%x", sha256.Sum256(b)))
+
+	// A build imported by name keeps its ID until it's applied with a
+	// configuration.
+	if !data.ConfigContents.IsNull() {
+		id, err := fingerprint(ctx, data)
+		if err != nil {
+			resp.Diagnostics.AddError("Client Error", err.Error())
+			return
+		}
+		data.Id = types.StringValue(id)
+	}
 
 	// Save updated data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
@@ -142,91 +381,445 @@ func (r *BuildResource) Update(ctx context.Context, req resource.UpdateRequest,
 		return
 	}
 
-	if err := r.doBuild(ctx, data); err != nil {
+	var prior BuildResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &prior)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	base := r
+
+	// Build with this resource's overrides of the provider's options.
+	r, err := r.withOverrides(ctx, data)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	archs, err := r.archs(ctx, data)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	data.EffectiveArchs = archsValue(archs)
+	if err := r.resolvePlanned(ctx, &data, archs, prior.Id.ValueString()); err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+
+	bctx, cancel, limit, err := withTimeout(ctx, data.Timeouts, "update")
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("timeouts").AtName("update"), "Invalid timeout", err.Error())
+		return
+	}
+	defer cancel()
+	if err := r.doBuild(bctx, data, archs); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			resp.Diagnostics.AddError("Build timed out", fmt.Sprintf("Builds did not complete within %s:\n%v", limit, err))
+			return
+		}
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+
+	apks, err := r.apks(data)
+	if err != nil {
 		resp.Diagnostics.AddError("Client Error", err.Error())
 		return
 	}
+	outputs, err := apks.outputs(archs)
+	if err != nil {
+		resp.Diagnostics.AddError("Error reading packages", err.Error())
+		return
+	}
+	data.APKs = outputs
+
+	sboms, err := apks.sboms(archs)
+	if err != nil {
+		resp.Diagnostics.AddError("Error reading SBOM", err.Error())
+		return
+	}
+	data.SBOMs = sboms
+
+	provenance, err := apks.provenance(archs)
+	if err != nil {
+		resp.Diagnostics.AddError("Error reading provenance", err.Error())
+		return
+	}
+	data.Provenance = provenance
+
+	_, hash, err := r.sourceHash(data)
+	if err != nil {
+		resp.Diagnostics.AddError("Error hashing source directory", err.Error())
+		return
+	}
+	data.SourceHash = types.StringValue(hash)
 
-	// ID is the sha256 of the JSON-serialized input config,
-	// to ensure the resource is updated if the changes.
-	b, err := json.Marshal(data.Config.String())
+	id, err := fingerprint(ctx, data)
 	if err != nil {
 		resp.Diagnostics.AddError("Client Error", err.Error())
 		return
 	}
-	data.Id = types.StringValue(fmt.Sprintf("%x", sha256.Sum256(b)))
+	data.Id = types.StringValue(id)
+
+	if err := base.removeSuperseded(ctx, prior, apks); err != nil {
+		resp.Diagnostics.AddWarning("Previous epoch not removed", err.Error())
+	}
 
 	// Save updated data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
 
-func (r *BuildResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+// removeSuperseded removes the packages of the prior build once an update
+// has rebuilt them at a new epoch, in place, like Delete would. They're left
+// for retain_versions to prune, if it's set, or retain_on_delete is.
+func (r *BuildResource) removeSuperseded(ctx context.Context, prior BuildResourceModel, built builtAPKs) error {
+	if prior.ConfigContents.IsNull() || prior.RetainOnDelete.ValueBool() {
+		return nil
+	}
+	r, err := r.withOverrides(ctx, prior)
+	if err != nil {
+		return err
+	}
+	if r.popts.retainVersions > 0 {
+		return nil
+	}
+	apks, err := r.apks(prior)
+	if err != nil {
+		return err
+	}
+	if apks.epoch == built.epoch && apks.version == built.version {
+		return nil
+	}
+	var archs []string
+	if diags := prior.EffectiveArchs.ElementsAs(ctx, &archs, false); diags.HasError() {
+		return fmt.Errorf("reading effective_archs: %v", diags.Errors())
+	}
+	return r.removeBuilt(ctx, prior, apks, archs)
+}
+
+// encodedConfig matches the errors melange reports for the attributes of
+// config that aren't in melange configurations.
+var encodedConfig = regexp.MustCompile(`field (provides|provider_priority|run_as) not found`)
+
+func (r *BuildResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	if req.Plan.Raw.IsNull() {
+		// The resource is being destroyed.
+		return
+	}
 	var data BuildResourceModel
-	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
-	// Nothing to delete.
+	if data.Config.IsUnknown() || data.ConfigContents.IsUnknown() || data.SourceDir.IsUnknown() || data.ConfigDir.IsUnknown() || data.Dir.IsUnknown() ||
+		data.Env.IsUnknown() || data.EnvByArch.IsUnknown() || data.SensitiveEnv.IsUnknown() || data.Vars.IsUnknown() {
+		// The source directory and fingerprint aren't known until apply.
+		resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("id"), types.StringUnknown())...)
+		return
+	}
+	if _, err := parseMelangeConfig([]byte(data.ConfigContents.ValueString())); err != nil {
+		detail := err.Error()
+		if encodedConfig.MatchString(detail) {
+			detail += "\n\nconfig_contents looks like yamlencode(config), which isn't a melange configuration: config only summarizes the package and its build environment, with Terraform's attribute names. Edit the configuration's YAML instead."
+		}
+		resp.Diagnostics.AddAttributeError(path.Root("config_contents"), "Invalid melange configuration", detail)
+		return
+	}
+
+	r, err := r.withOverrides(ctx, data)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	_, hash, err := r.sourceHash(data)
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("source_dir"), "Error hashing source directory", err.Error())
+		return
+	}
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("source_hash"), hash)...)
+	data.SourceHash = types.StringValue(hash)
+	id, err := fingerprint(ctx, data)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("id"), id)...)
+
+	if !req.State.Raw.IsNull() {
+		var state BuildResourceModel
+		resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+		// Don't replace packages built before source_hash was recorded.
+		if !state.SourceHash.IsNull() && state.SourceHash.ValueString() != hash {
+			resp.RequiresReplace = append(resp.RequiresReplace, path.Root("source_hash"))
+		}
+		// Builds imported by name don't know their package until they're
+		// applied with a configuration.
+		if !state.ConfigContents.IsNull() {
+			prior, err := r.apks(state)
+			if err != nil {
+				resp.Diagnostics.AddError("Client Error", err.Error())
+				return
+			}
+			next, err := r.apks(data)
+			if err != nil {
+				resp.Diagnostics.AddError("Client Error", err.Error())
+				return
+			}
+			// A new name or version produces new APKs, so replace the build
+			// to remove the old ones. Epochs are bumped in place.
+			if prior.names[0] != next.names[0] || prior.version != next.version {
+				resp.RequiresReplace = append(resp.RequiresReplace, path.Root("config"))
+			}
+		}
+	}
+
+	if data.Archs.IsUnknown() {
+		// What's built isn't known until apply.
+		return
+	}
+	archs, err := r.archs(ctx, data)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	if data.EnvFile.IsNull() {
+		for _, arch := range archs {
+			if envFile := r.implicitEnvFile(arch); envFile != "" {
+				resp.Diagnostics.AddAttributeWarning(path.Root("env_file"), "Implicit environment file is deprecated",
+					fmt.Sprintf("%s is loaded into the build for %s because env_file isn't set. Set env_file, env or env_by_arch instead.", envFile, arch))
+			}
+		}
+	}
+	if data.SourceDir.IsNull() {
+		srcdir, err := r.implicitSourceDir(data)
+		if err != nil {
+			resp.Diagnostics.AddError("Client Error", err.Error())
+			return
+		}
+		if srcdir != "" {
+			resp.Diagnostics.AddAttributeWarning(path.Root("source_dir"), "Implicit source directory is deprecated",
+				fmt.Sprintf("%s is copied into the build's workspace because it's named after the package and source_dir isn't set. The next major release won't copy it. Set source_dir to it instead.", srcdir))
+		} else {
+			resp.Diagnostics.AddAttributeWarning(path.Root("source_dir"), "Copying the working directory is deprecated",
+				"The working directory is copied into the build's workspace because source_dir isn't set. The next major release starts the build with an empty workspace instead. Set source_dir to the directory to copy.")
+		}
+	}
+
+	if !req.State.Raw.IsNull() && resp.Plan.Raw.Equal(req.State.Raw) {
+		// Nothing is built when nothing changes, so keep what the last
+		// apply did.
+		return
+	}
+	var priorID types.String
+	if !req.State.Raw.IsNull() {
+		resp.Diagnostics.Append(req.State.GetAttribute(ctx, path.Root("id"), &priorID)...)
+	}
+	data.Id = types.StringValue(id)
+	actions, apks, err := r.planned(data, archs)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("planned_actions"), actions)...)
+	if r.popts.offline && plansBuild(actions) {
+		// Sources missing from the cache may be cached by
+		// melange_source_cache before the build runs, but git-checkout
+		// sources never are.
+		entries, err := sourceCacheEntries([]byte(data.ConfigContents.ValueString()), archs)
+		if err != nil {
+			resp.Diagnostics.AddError("Client Error", err.Error())
+			return
+		}
+		if offline := networkOnlyEntries(entries); len(offline) != 0 {
+			resp.Diagnostics.AddAttributeError(path.Root("config_contents"), "Package can't be built offline",
+				fmt.Sprintf("The provider is offline, but melange's git-checkout pipeline always clones from the network:\n  %s", strings.Join(offline, "\n  ")))
+			return
+		}
+	}
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("planned_apks"), apks)...)
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("fingerprint_changed"), priorID.ValueString() != id)...)
 }
 
-func (r *BuildResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	resource.ImportStatePassthroughID(ctx, path.Root("id"), req, resp)
+// archs returns the architectures to build the package for.
+func (r *BuildResource) archs(ctx context.Context, data BuildResourceModel) ([]apkotypes.Architecture, error) {
+	cfg, err := parseConfigValue(ctx, data.Config)
+	if err != nil {
+		return nil, err
+	}
+	var override []string
+	if diags := data.Archs.ElementsAs(ctx, &override, false); diags.HasError() {
+		return nil, fmt.Errorf("reading archs: %v", diags.Errors())
+	}
+	targets, err := targetArchitectures([]byte(data.ConfigContents.ValueString()))
+	if err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+	return effectiveArchs(targets, override, cfg.Environment.Archs, r.popts.archs)
 }
 
-func (r *BuildResource) doBuild(ctx context.Context, data BuildResourceModel) error {
-	var cfg Configuration
-	if diags := reflect.AssignValue(data.Config, &cfg); diags.HasError() {
-		return fmt.Errorf("assigning value: %v", diags.Errors())
+func (r *BuildResource) doBuild(ctx context.Context, data BuildResourceModel, archs []apkotypes.Architecture) error {
+	cfg, err := parseConfigValue(ctx, data.Config)
+	if err != nil {
+		return err
+	}
+	name := cfg.Package.Name.ValueString()
+
+	retry, err := parseRetryPolicy(ctx, data.Retry)
+	if err != nil {
+		return err
+	}
+
+	contents, err := buildContents(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	srcdir, srcHash, err := r.sourceHash(data)
+	if err != nil {
+		return err
+	}
+	data.SourceHash = types.StringValue(srcHash)
+	fp, err := fingerprint(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	// melange's build API requires files, so write them to a scratch
+	// directory that's removed once every arch is built, unless we're asked
+	// to keep it.
+	keep := data.KeepWorkspace.ValueBool()
+	scratch, err := r.scratchDir(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if keep {
+			tflog.Info(ctx, fmt.Sprintf("keeping the workspace of %s in %s", name, scratch))
+			return
+		}
+		if err := os.RemoveAll(scratch); err != nil {
+			tflog.Warn(ctx, fmt.Sprintf("unable to remove %s: %v", scratch, err))
+		}
+	}()
+	configPath := filepath.Join(scratch, name+".yaml")
+	if err := os.WriteFile(configPath, contents, 0o644); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+
+	pipelineDir, err := r.popts.localPipelines(ctx, data.ConfigDir.ValueString(), data.PipelineDirs, scratch)
+	if err != nil {
+		return err
 	}
 
-	var bcs []*build.Build
-	for _, arch := range cfg.Environment.Archs {
+	// Don't copy the scratch directories into the workspace along with
+	// the working directory.
+	var ignorePath string
+	if srcdir == "" {
+		if ignorePath, err = workingDirIgnoreFile(r.popts.dir, scratch); err != nil {
+			return err
+		}
+	}
+
+	apks, err := r.apks(data)
+	if err != nil {
+		return err
+	}
+
+	type archBuild struct {
+		arch    apkotypes.Architecture
+		opts    []build.Option
+		logPath string
+		bc      *build.Build
+		// verifyOnly is set for packages that are already built, which
+		// are only rebuilt to check they're reproducible.
+		verifyOnly bool
+	}
+	var builds []archBuild
+	for _, arch := range archs {
 		// See if we already have the package built, and skip if so -- unless force_update is true.
-		id := fmt.Sprintf("%s-%s-r%d", cfg.Package.Name, cfg.Package.Version, cfg.Package.Epoch)
-		apk := id + ".apk"
-		apkPath := filepath.Join("./packages", arch.ToAPK(), apk)
-		if !data.ForceUpdate.ValueBool() {
-			if _, err := os.Stat(apkPath); err == nil {
+		id := fmt.Sprintf("%s-%s-r%d", name, cfg.Package.Version.ValueString(), cfg.Package.Epoch.ValueInt64())
+		apkPath := filepath.Join(r.popts.dir, "packages", arch.ToAPK(), id+".apk")
+		verifyOnly := false
+		switch archAction(apks, arch, fp, srcHash, data.ForceUpdate.ValueBool()) {
+		case actionSkip:
+			if !data.VerifyReproducible.ValueBool() {
 				tflog.Trace(ctx, fmt.Sprintf("skipping %s, already built", apkPath))
 				continue
 			}
+			tflog.Trace(ctx, fmt.Sprintf("not rebuilding %s, already built, but checking it's reproducible", apkPath))
+			verifyOnly = true
+		case actionRebuild:
+			if missing := apks.missing(arch); len(missing) != 0 {
+				tflog.Trace(ctx, fmt.Sprintf("rebuilding %s, missing %s", apkPath, strings.Join(missing, ", ")))
+			} else {
+				tflog.Trace(ctx, fmt.Sprintf("rebuilding %s, its inputs have changed", apkPath))
+			}
 		}
 
-		// Write the config to a temp file.
-		// TODO(jason): This is kind of gross, but Melange's build API requires a file path.
-		tmp, err := os.CreateTemp("", fmt.Sprintf("%s-*.yaml", cfg.Package.Name))
-		if err != nil {
-			return fmt.Errorf("creating temporary file: %v", err)
-		}
-		if err := os.WriteFile(tmp.Name(), []byte(data.ConfigContents.ValueString()), 0644); err != nil {
-			return fmt.Errorf("writing config to temporary file: %v", err)
-		}
-		tflog.Trace(ctx, fmt.Sprintf("will build %s for %s", cfg.Package.Name, arch))
+		tflog.Trace(ctx, fmt.Sprintf("will build %s for %s", name, arch))
+		logPath := filepath.Join(r.popts.dir, "packages", arch.ToAPK(), id+".log")
 		opts := []build.Option{build.WithArch(arch),
-			build.WithConfig(tmp.Name()),
+			build.WithConfig(configPath),
 			build.WithExtraRepos(r.popts.repositories),
 			build.WithExtraKeys(r.popts.keyring),
-			build.WithPipelineDir(filepath.Join(r.popts.dir, "pipelines")),
+			build.WithPipelineDir(pipelineDir),
 			build.WithOutDir(filepath.Join(r.popts.dir, "packages")),
 			build.WithRunner(r.popts.runner),
-			build.WithCacheDir(filepath.Join(r.popts.dir, "melange-cache")),
+			build.WithCacheDir(r.popts.cacheDir()),
 			// TF swallows logs, so write logs to a file.
-			build.WithLogPolicy([]string{filepath.Join(r.popts.dir, "packages", arch.ToAPK(), id+".log")}),
-			build.WithGenerateIndex(true),
+			build.WithLogPolicy([]string{logPath}),
+			// The index is updated once the build is done; see indexMu.
+			build.WithGenerateIndex(false),
 		}
-		// Add source dir if it exists.
-		srcdir := filepath.Join(r.popts.dir, cfg.Package.Name)
-		if _, err := os.Stat(srcdir); err == nil {
+		if verifyOnly {
+			// Build with the date the package was built with, which it
+			// may not have been pinned to.
+			date, err := apkBuildDate(apkPath)
+			if err != nil {
+				return fmt.Errorf("verifying reproducibility for %s: %w", arch, err)
+			}
+			opts = append(opts, build.WithBuildDate(date))
+		} else if data.VerifyReproducible.ValueBool() {
+			// Pin the build date so both builds use the same SOURCE_DATE_EPOCH.
+			// melange still prefers SOURCE_DATE_EPOCH from the environment.
+			opts = append(opts, build.WithBuildDate(""))
+		}
+		if srcdir != "" {
 			opts = append(opts, build.WithSourceDir(srcdir))
+		} else {
+			// Otherwise melange copies its working directory, which is
+			// deprecated; see ModifyPlan.
+			opts = append(opts, build.WithWorkspaceIgnore(ignorePath))
+		}
+		if keep {
+			// melange removes the workspace of a successful build, so
+			// this keeps only those of failed builds.
+			opts = append(opts, build.WithWorkspaceDir(filepath.Join(scratch, "workspace")))
 		}
 		// Add signing key if it exists.
 		signingKey := filepath.Join(r.popts.dir, r.popts.signingKey)
 		if _, err := os.Stat(signingKey); err == nil {
 			opts = append(opts, build.WithSigningKey(signingKey))
 		}
-		// Add env file if it exists.
-		envFile := filepath.Join(r.popts.dir, fmt.Sprintf("build-%s.env", arch))
-		if _, err := os.Stat(envFile); err == nil {
+		// Add env file if it exists, unless one is given.
+		envFile := data.EnvFile.ValueString()
+		if envFile == "" {
+			envFile = r.implicitEnvFile(arch)
+		}
+		env, err := buildEnv(ctx, data, arch.ToAPK())
+		if err != nil {
+			return err
+		}
+		if len(env) != 0 {
+			merged := filepath.Join(scratch, fmt.Sprintf("build-%s.env", arch.ToAPK()))
+			if err := writeEnvFile(merged, envFile, env); err != nil {
+				return err
+			}
+			envFile = merged
+		}
+		if envFile != "" {
 			opts = append(opts, build.WithEnvFile(envFile))
 		}
 		// Add namespace if it's set.
@@ -234,21 +827,439 @@ func (r *BuildResource) doBuild(ctx context.Context, data BuildResourceModel) er
 			opts = append(opts, build.WithNamespace(r.popts.namespace))
 		}
 
-		bc, err := build.New(ctx, opts...)
+		if verifyOnly {
+			builds = append(builds, archBuild{arch: arch, opts: opts, logPath: logPath, verifyOnly: true})
+			continue
+		}
+
+		// melange doesn't truncate an existing log file.
+		if err := os.Remove(logPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing old build log: %w", err)
+		}
+		bc, err := r.popts.newBuild(ctx, opts...)
+		if err != nil {
+			return fmt.Errorf("building %s for %s: %w", name, arch, err)
+		}
+		builds = append(builds, archBuild{arch: arch, opts: opts, logPath: logPath, bc: bc})
+	}
+	if r.popts.offline && len(builds) != 0 {
+		entries, err := sourceCacheEntries(contents, archs)
 		if err != nil {
-			return fmt.Errorf("building %s for %s: %w", cfg.Package.Name, arch, err)
+			return fmt.Errorf("finding sources: %w", err)
+		}
+		if missing := missingCacheEntries(r.popts.cacheDir(), entries); len(missing) != 0 {
+			return fmt.Errorf("offline mode: %d source(s) of %s can't be served from %s:\n  %s", len(missing), name, r.popts.cacheDir(), strings.Join(missing, "\n  "))
+		}
+	}
+
+	var signer provenanceSigner
+	if data.SignProvenance.ValueBool() {
+		key := filepath.Join(r.popts.dir, r.popts.signingKey)
+		if !fileExists(key) {
+			return fmt.Errorf("sign_provenance is set, but signing key %s doesn't exist", key)
 		}
-		bcs = append(bcs, bc)
+		signer = rsaSigner{keyFile: key}
+	}
+
+	// Each arch builds concurrently, and one failing doesn't stop the
+	// others. They only wait on each other for indexMu, to update the index
+	// once they're built.
+	errs := make([]error, len(builds))
+	var wg sync.WaitGroup
+	for i, b := range builds {
+		i, b := i, b
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b.verifyOnly {
+				errs[i] = r.verifyReproducible(ctx, b.arch, b.opts, b.logPath, keep)
+				return
+			}
+			var (
+				final     *build.Build
+				installed *installedRecorder
+				started   time.Time
+			)
+			what := fmt.Sprintf("building %s for %s", name, b.arch)
+			errs[i] = retry.run(ctx, what, func(ctx context.Context, attempt int) ([]byte, error) {
+				bc := b.bc
+				if attempt > 1 {
+					// Keep the failed attempt's log around, and start the next
+					// attempt from a fresh build context.
+					prev := strings.TrimSuffix(b.logPath, ".log") + fmt.Sprintf(".attempt-%d.log", attempt-1)
+					if err := os.Rename(b.logPath, prev); err != nil && !os.IsNotExist(err) {
+						return nil, fmt.Errorf("saving build log: %w", err)
+					}
+					if keep {
+						ws := filepath.Join(scratch, "workspace", b.arch.ToAPK())
+						if err := os.Rename(ws, fmt.Sprintf("%s.attempt-%d", ws, attempt-1)); err != nil && !os.IsNotExist(err) {
+							return nil, fmt.Errorf("saving workspace: %w", err)
+						}
+					}
+					var err error
+					if bc, err = r.popts.newBuild(ctx, b.opts...); err != nil {
+						return nil, err
+					}
+				}
+				installed = &installedRecorder{Runner: bc.Runner, bc: bc}
+				bc.Runner = installed
+				final, started = bc, time.Now()
+				err := buildPackage(ctx, bc, keep)
+				log, _ := os.ReadFile(b.logPath)
+				return log, err
+			})
+			finished := time.Now()
+			if errs[i] == nil && data.VerifyReproducible.ValueBool() {
+				errs[i] = r.verifyReproducible(ctx, b.arch, b.opts, b.logPath, keep)
+			}
+			if errs[i] == nil {
+				errs[i] = r.writeProvenance(apks, buildProvenance{
+					arch:            b.arch,
+					config:          []byte(data.ConfigContents.ValueString()),
+					builderImage:    final.WorkspaceConfig().ImgRef,
+					installed:       installed.installed,
+					runner:          r.popts.runner,
+					namespace:       r.popts.namespace,
+					repositories:    final.ExtraRepos,
+					keyring:         final.ExtraKeys,
+					sourceDir:       srcdir,
+					sourceHash:      srcHash,
+					fingerprint:     fp,
+					sourceDateEpoch: final.SourceDateEpoch,
+					started:         started,
+					finished:        finished,
+				}, signer)
+			}
+			if errs[i] == nil {
+				errs[i] = r.indexPackages(ctx, apks, b.arch)
+			}
+		}()
 	}
-	var errg errgroup.Group
-	for _, bc := range bcs {
-		bc := bc
-		errg.Go(func() error {
-			return bc.BuildPackage(ctx)
-		})
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// sboms extracts the SBOMs of the package and each subpackage built for each
+// arch.
+func (apks builtAPKs) sboms(archs []apkotypes.Architecture) (types.List, error) {
+	var vals []attr.Value
+	for _, arch := range archs {
+		for _, name := range apks.names {
+			apk := apks.path(arch, name)
+			if !fileExists(apk) && name != apks.names[0] {
+				// Not every subpackage produces an APK.
+				continue
+			}
+			path, contents, err := extractSBOM(apk)
+			if err != nil {
+				return types.List{}, err
+			}
+			v, err := sbomValue(arch.ToAPK(), name, path, contents)
+			if err != nil {
+				return types.List{}, err
+			}
+			vals = append(vals, v)
+		}
 	}
-	if err := errg.Wait(); err != nil {
+	return types.ListValueMust(sbomType, vals), nil
+}
+
+// provenance reads the provenance statements of the package and each
+// subpackage built for each arch.
+func (apks builtAPKs) provenance(archs []apkotypes.Architecture) (types.List, error) {
+	var vals []attr.Value
+	for _, arch := range archs {
+		for _, name := range apks.names {
+			path := provenancePath(apks.path(arch, name))
+			if !fileExists(path) {
+				// Not every subpackage produces an APK, and packages built
+				// before provenance was generated don't have any.
+				continue
+			}
+			v, err := provenanceValue(arch.ToAPK(), name, path)
+			if err != nil {
+				return types.List{}, err
+			}
+			vals = append(vals, v)
+		}
+	}
+	return types.ListValueMust(provenanceType, vals), nil
+}
+
+var builtAPKType = basetypes.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"arch":    basetypes.StringType{},
+		"package": basetypes.StringType{},
+		"path":    basetypes.StringType{},
+		"sha256":  basetypes.StringType{},
+	},
+}
+
+// outputs lists the APKs of the package and each subpackage built for each
+// arch, with their digests.
+func (apks builtAPKs) outputs(archs []apkotypes.Architecture) (types.List, error) {
+	var vals []attr.Value
+	for _, arch := range archs {
+		for _, name := range apks.names {
+			apk := apks.path(arch, name)
+			if !fileExists(apk) && name != apks.names[0] {
+				// Not every subpackage produces an APK.
+				continue
+			}
+			digest, err := fileDigest(apk)
+			if err != nil {
+				return types.List{}, err
+			}
+			vals = append(vals, basetypes.NewObjectValueMust(builtAPKType.AttrTypes, map[string]attr.Value{
+				"arch":    basetypes.NewStringValue(arch.ToAPK()),
+				"package": basetypes.NewStringValue(name),
+				"path":    basetypes.NewStringValue(apk),
+				"sha256":  basetypes.NewStringValue(digest),
+			}))
+		}
+	}
+	return types.ListValueMust(builtAPKType, vals), nil
+}
+
+// sourceHash returns the directory copied into the build's workspace, if
+// source_dir is set or the package has an implicit source directory, and its
+// digest. A relative source_dir is resolved against config_dir, if it's set.
+func (r *BuildResource) sourceHash(data BuildResourceModel) (string, string, error) {
+	dir := data.SourceDir.ValueString()
+	if dir == "" {
+		implicit, err := r.implicitSourceDir(data)
+		if implicit == "" || err != nil {
+			return "", "", err
+		}
+		dir = implicit
+	}
+	if cd := data.ConfigDir.ValueString(); cd != "" && !filepath.IsAbs(dir) {
+		dir = filepath.Join(cd, dir)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return "", "", fmt.Errorf("source_dir: %w", err)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	hash, err := hashSourceDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+	return dir, hash, nil
+}
+
+// scratchDirName is the directory under dir holding each build's scratch
+// directory.
+const scratchDirName = ".melange-scratch"
+
+// scratchDir creates a directory under dir for the files a build of the
+// package passes to melange.
+func (r *BuildResource) scratchDir(name string) (string, error) {
+	parent := filepath.Join(r.popts.dir, scratchDirName)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", fmt.Errorf("creating scratch directory: %w", err)
+	}
+	scratch, err := os.MkdirTemp(parent, name+"-*")
+	if err != nil {
+		return "", fmt.Errorf("creating scratch directory: %w", err)
+	}
+	return scratch, nil
+}
+
+// implicitSourceDir returns the directory named after the package in dir, if
+// it exists. It's copied into the workspace of builds without a source_dir,
+// but that's deprecated.
+func (r *BuildResource) implicitSourceDir(data BuildResourceModel) (string, error) {
+	cfg, err := parseMelangeConfig([]byte(data.ConfigContents.ValueString()))
+	if err != nil {
+		return "", fmt.Errorf("parsing config: %w", err)
+	}
+	dir := filepath.Join(r.popts.dir, cfg.Package.Name)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", nil
+	}
+	return dir, nil
+}
+
+// implicitEnvFile returns build-<arch>.env in dir, if it exists.
+func (r *BuildResource) implicitEnvFile(arch apkotypes.Architecture) string {
+	envFile := filepath.Join(r.popts.dir, fmt.Sprintf("build-%s.env", arch))
+	if !fileExists(envFile) {
+		return ""
+	}
+	return envFile
+}
+
+// writeProvenance writes provenance statements for the package and each
+// subpackage built for p.arch.
+func (r *BuildResource) writeProvenance(apks builtAPKs, p buildProvenance, signer provenanceSigner) error {
+	sources, err := sourceCacheEntries(p.config, []apkotypes.Architecture{p.arch})
+	if err != nil {
+		return fmt.Errorf("finding sources: %w", err)
+	}
+	p.sources = sources
+	for i, name := range apks.names {
+		apk := apks.path(p.arch, name)
+		if i != 0 && !fileExists(apk) {
+			continue
+		}
+		if err := p.write(apk, signer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// builtAPKs names the APKs a configuration produces.
+type builtAPKs struct {
+	dir, version string
+	epoch        uint64
+	names        []string        // the package, then its subpackages
+	conditional  map[string]bool // subpackages only built if their if condition holds
+}
+
+func (b builtAPKs) path(arch apkotypes.Architecture, name string) string {
+	return filepath.Join(b.dir, arch.ToAPK(), fmt.Sprintf("%s-%s-r%d.apk", name, b.version, b.epoch))
+}
+
+func (r *BuildResource) apks(data BuildResourceModel) (builtAPKs, error) {
+	cfg, err := parseMelangeConfig([]byte(data.ConfigContents.ValueString()))
+	if err != nil {
+		return builtAPKs{}, fmt.Errorf("parsing config: %w", err)
+	}
+	names := []string{cfg.Package.Name}
+	conditional := map[string]bool{}
+	for _, sp := range cfg.Subpackages {
+		names = append(names, sp.Name)
+		if sp.If != "" {
+			conditional[sp.Name] = true
+		}
+	}
+	return builtAPKs{dir: filepath.Join(r.popts.dir, "packages"), version: cfg.Package.Version, epoch: cfg.Package.Epoch, names: names, conditional: conditional}, nil
+}
+
+// apkBuildDate returns the date the APK at path was built with, its
+// SOURCE_DATE_EPOCH, as RFC 3339. melange leaves the date out of packages
+// built at epoch 0, like those pinned by verify_reproducible, so those return
+// "", which build.WithBuildDate takes to mean epoch 0.
+func apkBuildDate(path string) (string, error) {
+	info, err := readPkgInfo(path)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := info["builddate"]; !ok {
+		return "", nil
+	}
+	sec, err := strconv.ParseInt(info["builddate"], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("reading build date of %s: %w", path, err)
+	}
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339), nil
+}
+
+// verifyReproducible builds the package again with the same options into a
+// scratch directory, and checks that every APK it produces has the same data
+// section as the first build's.
+func (r *BuildResource) verifyReproducible(ctx context.Context, arch apkotypes.Architecture, opts []build.Option, logPath string, keep bool) error {
+	scratch, err := os.MkdirTemp("", "melange-verify-*")
+	if err != nil {
+		return fmt.Errorf("creating scratch directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	verifyLog := strings.TrimSuffix(logPath, ".log") + ".verify.log"
+	if err := os.Remove(verifyLog); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing old build log: %w", err)
+	}
+	opts = append(append([]build.Option(nil), opts...),
+		build.WithOutDir(scratch),
+		build.WithLogPolicy([]string{verifyLog}),
+		build.WithGenerateIndex(false),
+	)
+	bc, err := r.popts.newBuild(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("verifying reproducibility for %s: %w", arch, err)
+	}
+	if err := buildPackage(ctx, bc, keep); err != nil {
+		return fmt.Errorf("verifying reproducibility for %s: second build failed: %w", arch, err)
+	}
+
+	apks, err := filepath.Glob(filepath.Join(scratch, arch.ToAPK(), "*.apk"))
+	if err != nil {
 		return err
 	}
+	var diffs []string
+	for _, second := range apks {
+		first := filepath.Join(r.popts.dir, "packages", arch.ToAPK(), filepath.Base(second))
+		if err := compareAPKs(first, second); err != nil {
+			diffs = append(diffs, fmt.Sprintf("%s: %v", filepath.Base(second), err))
+		}
+	}
+	if len(diffs) != 0 {
+		return fmt.Errorf("%s for %s is not reproducible:\n  %s", bc.Configuration.Package.Name, arch, strings.Join(diffs, "\n  "))
+	}
+	tflog.Info(ctx, fmt.Sprintf("verified %d package(s) for %s are reproducible", len(apks), arch))
 	return nil
 }
+
+// buildPackage runs the build, tearing down its container if the build is
+// cancelled or times out and reporting which pipeline step was running.
+//
+// melange leaves the workspace and guest directories of a failed build
+// behind. They're removed, unless keep is set.
+func buildPackage(ctx context.Context, bc *build.Build, keep bool) (err error) {
+	steps := &stepLogger{Logger: bc.Logger}
+	bc.Logger = steps
+
+	defer func() {
+		if err != nil {
+			err = failedBuildDirs(ctx, bc, keep, err)
+		}
+	}()
+
+	err = bc.BuildPackage(ctx)
+	if ctx.Err() == nil {
+		return err
+	}
+
+	// melange terminates the pod using the build's context, which fails once
+	// that context is done, so do it again with one that isn't.
+	if cfg := bc.WorkspaceConfig(); bc.Runner != nil && cfg.PodID != "" {
+		tctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		if err := bc.Runner.TerminatePod(tctx, cfg); err != nil {
+			tflog.Warn(ctx, fmt.Sprintf("unable to terminate pod %s: %v", cfg.PodID, err))
+		}
+	}
+
+	where := "before any pipeline step started"
+	if step := steps.Step(); step != "" {
+		where = fmt.Sprintf("while running step %q", step)
+	}
+	return fmt.Errorf("building %s for %s stopped %s: %w", bc.Configuration.Package.Name, bc.Arch.ToAPK(), where, ctx.Err())
+}
+
+// failedBuildDirs removes the directories melange leaves behind when a build
+// fails, or if keep is set, adds where they are to err.
+func failedBuildDirs(ctx context.Context, bc *build.Build, keep bool, err error) error {
+	var dirs []string
+	for _, dir := range []string{bc.WorkspaceDir, bc.GuestDir} {
+		if dir != "" && fileExists(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	if keep {
+		if len(dirs) != 0 {
+			err = fmt.Errorf("%w\nkept %s", err, strings.Join(dirs, " and "))
+		}
+		return err
+	}
+	for _, dir := range dirs {
+		if rerr := os.RemoveAll(dir); rerr != nil {
+			tflog.Warn(ctx, fmt.Sprintf("unable to remove %s: %v", dir, rerr))
+		}
+	}
+	return err
+}
@@ -7,13 +7,18 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"strings"
 	"testing"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/plancheck"
+	"github.com/hashicorp/terraform-plugin-testing/terraform"
 	"gitlab.alpinelinux.org/alpine/go/repository"
 )
 
@@ -46,13 +51,37 @@ data "melange_config" "minimal" {
 }
 
 resource "melange_build" "build" {
-	config          = data.melange_config.minimal.config
-	config_contents = data.melange_config.minimal.config_contents
+	config           = data.melange_config.minimal.config
+	config_contents  = data.melange_config.minimal.config_contents
+	retain_on_delete = true
 }`,
 			Check: resource.ComposeAggregateTestCheckFunc(
 				resource.TestCheckResourceAttr("melange_build.build", "config.package.name", "minimal"),
 				resource.TestCheckResourceAttr("melange_build.build", "config.package.epoch", "3"),
-				resource.TestCheckResourceAttr("melange_build.build", "id", "100ffaf3d06713d2737fdcbbb2176ba96161671fac4cc2d1b84000edffd187f3"),
+				resource.TestCheckResourceAttr("melange_build.build", "id", "0907faf4f8701bd37605abb7212052d2cb9df00c090a0723441c41aea747ebc3"),
+				resource.TestCheckResourceAttr("data.melange_config.minimal", "effective_archs.#", "1"),
+				resource.TestCheckResourceAttr("data.melange_config.minimal", "effective_archs.0", arch),
+				resource.TestCheckResourceAttr("melange_build.build", "effective_archs.#", "1"),
+				resource.TestCheckResourceAttr("melange_build.build", "effective_archs.0", arch),
+				resource.TestCheckResourceAttr("melange_build.build", "apks.#", "1"),
+				resource.TestCheckResourceAttr("melange_build.build", "apks.0.arch", arch),
+				resource.TestCheckResourceAttr("melange_build.build", "apks.0.package", "minimal"),
+				resource.TestCheckResourceAttr("melange_build.build", "apks.0.path", fmt.Sprintf("packages/%s/minimal-0.0.1-r3.apk", arch)),
+				resource.TestCheckResourceAttr("melange_build.build", "sboms.#", "1"),
+				resource.TestCheckResourceAttr("melange_build.build", "sboms.0.arch", arch),
+				resource.TestCheckResourceAttr("melange_build.build", "sboms.0.package", "minimal"),
+				resource.TestCheckResourceAttr("melange_build.build", "sboms.0.path", fmt.Sprintf("packages/%s/minimal-0.0.1-r3.spdx.json", arch)),
+				resource.TestCheckResourceAttr("melange_build.build", "sboms.0.packages.0.name", "minimal"),
+				resource.TestCheckResourceAttr("melange_build.build", "provenance.#", "1"),
+				resource.TestCheckResourceAttr("melange_build.build", "provenance.0.package", "minimal"),
+				resource.TestCheckResourceAttr("melange_build.build", "provenance.0.path", fmt.Sprintf("packages/%s/minimal-0.0.1-r3.intoto.json", arch)),
+				resource.TestCheckResourceAttr("melange_build.build", "provenance.0.signed", "false"),
+				resource.TestMatchResourceAttr("melange_build.build", "provenance.0.statement", regexp.MustCompile(`"predicateType": "https://slsa.dev/provenance/v1"`)),
+				resource.TestCheckResourceAttr("melange_build.build", "planned_actions.%", "1"),
+				resource.TestCheckResourceAttr("melange_build.build", "planned_actions."+arch, "build"),
+				resource.TestCheckResourceAttr("melange_build.build", "planned_apks.#", "1"),
+				resource.TestCheckResourceAttr("melange_build.build", "planned_apks.0", fmt.Sprintf("packages/%s/minimal-0.0.1-r3.apk", arch)),
+				resource.TestCheckResourceAttr("melange_build.build", "fingerprint_changed", "true"),
 			),
 		}},
 	})
@@ -98,12 +127,13 @@ resource "melange_build" "build" {
 	resource.Test(t, resource.TestCase{
 		ProtoV6ProviderFactories: providerFactories,
 		Steps: []resource.TestStep{{
+			// config isn't a melange configuration, so it can't be edited
+			// and encoded as one.
 			Config: `
 data "melange_config" "minimal" {
 	config_contents = file("${path.module}/testdata/minimal.yaml")
 }
 
-// Simulate bumping the epoch.
 locals {
 	updated = merge(data.melange_config.minimal.config, {
 		package = {
@@ -115,13 +145,27 @@ locals {
 }
 
 resource "melange_build" "build" {
-	config          = local.updated
-	config_contents = yamlencode(local.updated)
+	config           = local.updated
+	config_contents  = yamlencode(local.updated)
+	retain_on_delete = true
+}`,
+			ExpectError: regexp.MustCompile(`config_contents looks like yamlencode\(config\)`),
+		}, {
+			Config: `
+// Simulate bumping the epoch.
+data "melange_config" "minimal" {
+	config_contents = replace(file("${path.module}/testdata/minimal.yaml"), "epoch: 3", "epoch: 4")
+}
+
+resource "melange_build" "build" {
+	config           = data.melange_config.minimal.config
+	config_contents  = data.melange_config.minimal.config_contents
+	retain_on_delete = true
 }`,
 			Check: resource.ComposeAggregateTestCheckFunc(
 				resource.TestCheckResourceAttr("melange_build.build", "config.package.name", "minimal"),
 				resource.TestCheckResourceAttr("melange_build.build", "config.package.epoch", "4"),
-				resource.TestCheckResourceAttr("melange_build.build", "id", "35a03ba74b6643966e4baabda5544d9da90f1b48fdb96e69d16a39a8c971d972"),
+				resource.TestCheckResourceAttr("melange_build.build", "id", "2aa9984ac649a8094b844245f5c22ba0d0b6638aef38f1c2831d68a7b91e8a3f"),
 			),
 		}},
 	})
@@ -146,3 +190,735 @@ resource "melange_build" "build" {
 		}
 	}
 }
+
+func TestAccBuildResource_Replace(t *testing.T) {
+	config := func(version string, epoch int, retain bool) string {
+		return fmt.Sprintf(`
+data "melange_config" "minimal" {
+	config_contents = replace(replace(file("${path.module}/testdata/minimal.yaml"), "version: 0.0.1", "version: %s"), "epoch: 3", "epoch: %d")
+}
+
+resource "melange_build" "build" {
+	config           = data.melange_config.minimal.config
+	config_contents  = data.melange_config.minimal.config_contents
+	retain_on_delete = %t
+}`, version, epoch, retain)
+	}
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: config("0.0.1", 5, false),
+			Check:  checkBuilt("minimal", "0.0.1-r5", true),
+		}, {
+			// Bumping the version replaces the build, removing the old one.
+			Config: config("0.0.2", 5, false),
+			ConfigPlanChecks: resource.ConfigPlanChecks{
+				PreApply: []plancheck.PlanCheck{plancheck.ExpectResourceAction("melange_build.build", plancheck.ResourceActionReplace)},
+			},
+			Check: resource.ComposeAggregateTestCheckFunc(
+				checkBuilt("minimal", "0.0.1-r5", false),
+				checkBuilt("minimal", "0.0.2-r5", true),
+			),
+		}, {
+			// Bumping the epoch rebuilds it in place, removing the old epoch.
+			Config: config("0.0.2", 6, false),
+			ConfigPlanChecks: resource.ConfigPlanChecks{
+				PreApply: []plancheck.PlanCheck{plancheck.ExpectResourceAction("melange_build.build", plancheck.ResourceActionUpdate)},
+			},
+			Check: resource.ComposeAggregateTestCheckFunc(
+				checkBuilt("minimal", "0.0.2-r5", false),
+				checkBuilt("minimal", "0.0.2-r6", true),
+			),
+		}, {
+			Config: config("0.0.2", 6, true),
+			ConfigPlanChecks: resource.ConfigPlanChecks{
+				PreApply: []plancheck.PlanCheck{plancheck.ExpectResourceAction("melange_build.build", plancheck.ResourceActionUpdate)},
+			},
+		}, {
+			// Unless it's retained.
+			Config: config("0.0.3", 6, true),
+			ConfigPlanChecks: resource.ConfigPlanChecks{
+				PreApply: []plancheck.PlanCheck{plancheck.ExpectResourceAction("melange_build.build", plancheck.ResourceActionReplace)},
+			},
+			Check: resource.ComposeAggregateTestCheckFunc(
+				checkBuilt("minimal", "0.0.2-r6", true),
+				checkBuilt("minimal", "0.0.3-r6", true),
+			),
+		}},
+	})
+}
+
+func TestAccBuildResource_RetainVersions(t *testing.T) {
+	config := func(epoch int) string {
+		return fmt.Sprintf(`
+provider "melange" {
+	retain_versions = 2
+}
+
+data "melange_config" "minimal" {
+	config_contents = replace(replace(file("${path.module}/testdata/minimal.yaml"), "name: minimal", "name: retained"), "epoch: 3", "epoch: %d")
+}
+
+resource "melange_build" "build" {
+	config           = data.melange_config.minimal.config
+	config_contents  = data.melange_config.minimal.config_contents
+	retain_on_delete = true
+}`, epoch)
+	}
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: config(0),
+			Check:  checkBuilt("retained", "0.0.1-r0", true),
+		}, {
+			Config: config(1),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				checkBuilt("retained", "0.0.1-r0", true),
+				checkBuilt("retained", "0.0.1-r1", true),
+			),
+		}, {
+			// Only the two latest epochs are kept.
+			Config: config(2),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				checkBuilt("retained", "0.0.1-r0", false),
+				checkBuilt("retained", "0.0.1-r1", true),
+				checkBuilt("retained", "0.0.1-r2", true),
+			),
+		}},
+	})
+}
+
+// checkBuilt checks whether the package name at version, with its SBOM, is
+// in the packages directory and the index.
+func checkBuilt(name, version string, want bool) resource.TestCheckFunc {
+	return func(*terraform.State) error {
+		for _, ext := range []string{".apk", ".spdx.json"} {
+			fn := fmt.Sprintf("packages/%s/%s-%s%s", arch, name, version, ext)
+			if got := fileExists(fn); got != want {
+				return fmt.Errorf("%s exists: got %t, want %t", fn, got, want)
+			}
+		}
+		f, err := os.Open(fmt.Sprintf("packages/%s/APKINDEX.tar.gz", arch))
+		if os.IsNotExist(err) && !want {
+			// The index is removed with the last package in it.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer f.Close()
+		idx, err := repository.IndexFromArchive(f)
+		if err != nil {
+			return err
+		}
+		got := false
+		for _, p := range idx.Packages {
+			got = got || (p.Name == name && p.Version == version)
+		}
+		if got != want {
+			return fmt.Errorf("%s-%s indexed: got %t, want %t", name, version, got, want)
+		}
+		return nil
+	}
+}
+
+func TestAccBuildResource_Subpackages(t *testing.T) {
+	config := func(retain bool) string {
+		return fmt.Sprintf(`
+data "melange_config" "split" {
+	config_contents = file("${path.module}/testdata/subpackages.yaml")
+}
+
+resource "melange_build" "build" {
+	config           = data.melange_config.split.config
+	config_contents  = data.melange_config.split.config_contents
+	retain_on_delete = %t
+}`, retain)
+	}
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: config(true),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.melange_config.split", "config.subpackages.#", "1"),
+				resource.TestCheckResourceAttr("data.melange_config.split", "config.subpackages.0.name", "split-doc"),
+				resource.TestCheckResourceAttr("melange_build.build", "planned_apks.#", "2"),
+				resource.TestCheckResourceAttr("melange_build.build", "apks.#", "2"),
+				resource.TestCheckResourceAttr("melange_build.build", "apks.0.package", "split"),
+				resource.TestCheckResourceAttr("melange_build.build", "apks.1.package", "split-doc"),
+				resource.TestCheckResourceAttr("melange_build.build", "apks.1.path", fmt.Sprintf("packages/%s/split-doc-0.0.1-r0.apk", arch)),
+				resource.TestMatchResourceAttr("melange_build.build", "apks.1.sha256", regexp.MustCompile(`^[0-9a-f]{64}$`)),
+				resource.TestCheckResourceAttr("melange_build.build", "sboms.#", "2"),
+				checkBuilt("split", "0.0.1-r0", true),
+				checkBuilt("split-doc", "0.0.1-r0", true),
+			),
+		}},
+	})
+
+	// A missing subpackage is rebuilt, even though the package is up to date.
+	if err := os.Remove(fmt.Sprintf("packages/%s/split-doc-0.0.1-r0.apk", arch)); err != nil {
+		t.Fatal(err)
+	}
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: config(false),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("melange_build.build", "planned_actions."+arch, "rebuild"),
+				checkBuilt("split", "0.0.1-r0", true),
+				checkBuilt("split-doc", "0.0.1-r0", true),
+			),
+		}},
+		// Destroying the build removes the subpackage too.
+		CheckDestroy: resource.ComposeAggregateTestCheckFunc(
+			checkBuilt("split", "0.0.1-r0", false),
+			checkBuilt("split-doc", "0.0.1-r0", false),
+		),
+	})
+}
+
+func TestAccBuildResource_Import(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "minimal" {
+	config_file = "testdata/minimal.yaml"
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.minimal.config
+	config_contents = data.melange_config.minimal.config_contents
+	config_dir      = data.melange_config.minimal.config_dir
+}`,
+		}, {
+			// Importing by configuration path restores the configuration
+			// and the directory it's in.
+			ResourceName:      "melange_build.build",
+			ImportState:       true,
+			ImportStateId:     "testdata/minimal.yaml",
+			ImportStateVerify: true,
+			// What the last plan did isn't known for imported builds.
+			ImportStateVerifyIgnore: []string{"source_hash", "planned_actions", "planned_apks", "fingerprint_changed"},
+		}, {
+			ResourceName:            "melange_build.build",
+			ImportState:             true,
+			ImportStateId:           "minimal-0.0.1-r3",
+			ImportStateVerify:       true,
+			ImportStateVerifyIgnore: []string{"config", "config_contents", "config_dir", "id", "source_hash", "planned_actions", "planned_apks", "fingerprint_changed"},
+			// Without a configuration, the ID isn't replaced by a fingerprint.
+			ImportStateCheck: func(states []*terraform.InstanceState) error {
+				if len(states) != 1 {
+					return fmt.Errorf("got %d builds, want 1", len(states))
+				}
+				if got := states[0].ID; got != "minimal-0.0.1-r3" {
+					return fmt.Errorf("got ID %q, want minimal-0.0.1-r3", got)
+				}
+				return nil
+			},
+		}, {
+			ResourceName:  "melange_build.build",
+			ImportState:   true,
+			ImportStateId: "minimal-9.9.9-r0",
+			ExpectError:   regexp.MustCompile(`Cannot import non-existent remote object`),
+		}},
+	})
+}
+
+func TestAccBuildResource_Timeout(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "hang" {
+	config_contents = file("${path.module}/testdata/hang.yaml")
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.hang.config
+	config_contents = data.melange_config.hang.config_contents
+
+	timeouts {
+		create = "2m"
+	}
+}`,
+			ExpectError: regexp.MustCompile(`(?s)Build timed out.*while running step "hang forever"`),
+		}, {
+			Config: `
+data "melange_config" "hang" {
+	config_contents = file("${path.module}/testdata/hang.yaml")
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.hang.config
+	config_contents = data.melange_config.hang.config_contents
+
+	timeouts {
+		create = "soon"
+	}
+}`,
+			ExpectError: regexp.MustCompile(`Invalid timeout`),
+		}},
+	})
+}
+
+func TestAccBuildResource_KeepWorkspace(t *testing.T) {
+	t.Cleanup(func() { os.RemoveAll(scratchDirName) })
+	config := func(keep bool) string {
+		return fmt.Sprintf(`
+data "melange_config" "fail" {
+	config_contents = file("${path.module}/testdata/fail.yaml")
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.fail.config
+	config_contents = data.melange_config.fail.config_contents
+	keep_workspace  = %t
+}`, keep)
+	}
+	scratch := func() []string {
+		dirs, err := filepath.Glob(filepath.Join(scratchDirName, "fail-*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dirs
+	}
+
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config:      config(false),
+			ExpectError: regexp.MustCompile(`unable to run pipeline`),
+		}},
+	})
+	if dirs := scratch(); len(dirs) != 0 {
+		t.Errorf("scratch directories weren't removed: %v", dirs)
+	}
+
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config:      config(true),
+			ExpectError: regexp.MustCompile(`kept .*melange-scratch/fail-`),
+		}},
+	})
+	dirs := scratch()
+	if len(dirs) != 1 {
+		t.Fatalf("got scratch directories %v, want one", dirs)
+	}
+	for _, name := range []string{"fail.yaml", filepath.Join("workspace", arch, "left-behind")} {
+		if !fileExists(filepath.Join(dirs[0], name)) {
+			t.Errorf("%s wasn't kept in %s", name, dirs[0])
+		}
+	}
+}
+
+func TestAccBuildResource_VerifyReproducible(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "minimal" {
+	config_contents = file("${path.module}/testdata/minimal.yaml")
+}
+
+resource "melange_build" "build" {
+	config              = data.melange_config.minimal.config
+	config_contents     = data.melange_config.minimal.config_contents
+	force_update        = true
+	verify_reproducible = true
+}`,
+			Check: resource.TestCheckResourceAttr("melange_build.build", "verify_reproducible", "true"),
+		}},
+	})
+
+	// Packages that are already built are rebuilt to check them too.
+	verifyLog := fmt.Sprintf("packages/%s/minimal-0.0.1-r3.verify.log", arch)
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "minimal" {
+	config_contents = file("${path.module}/testdata/minimal.yaml")
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.minimal.config
+	config_contents = data.melange_config.minimal.config_contents
+}`,
+		}, {
+			PreConfig: func() {
+				if err := os.Remove(verifyLog); err != nil && !os.IsNotExist(err) {
+					t.Fatal(err)
+				}
+			},
+			Config: `
+data "melange_config" "minimal" {
+	config_contents = file("${path.module}/testdata/minimal.yaml")
+}
+
+resource "melange_build" "build" {
+	config              = data.melange_config.minimal.config
+	config_contents     = data.melange_config.minimal.config_contents
+	verify_reproducible = true
+}`,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("melange_build.build", "planned_actions."+arch, "skip"),
+				func(*terraform.State) error {
+					if !fileExists(verifyLog) {
+						return fmt.Errorf("%s wasn't rebuilt to verify it", verifyLog)
+					}
+					return nil
+				},
+			),
+		}},
+	})
+
+	// Packages verify_reproducible built at epoch 0 have no build date, and
+	// are verified again at epoch 0.
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "minimal" {
+	config_contents = file("${path.module}/testdata/minimal.yaml")
+}
+
+resource "melange_build" "build" {
+	config              = data.melange_config.minimal.config
+	config_contents     = data.melange_config.minimal.config_contents
+	force_update        = true
+	verify_reproducible = true
+}`,
+		}, {
+			PreConfig: func() {
+				if err := os.Remove(verifyLog); err != nil && !os.IsNotExist(err) {
+					t.Fatal(err)
+				}
+			},
+			// Changing keep_workspace applies the build again without
+			// changing its fingerprint.
+			Config: `
+data "melange_config" "minimal" {
+	config_contents = file("${path.module}/testdata/minimal.yaml")
+}
+
+resource "melange_build" "build" {
+	config              = data.melange_config.minimal.config
+	config_contents     = data.melange_config.minimal.config_contents
+	verify_reproducible = true
+	keep_workspace      = true
+}`,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("melange_build.build", "planned_actions."+arch, "skip"),
+				func(*terraform.State) error {
+					if !fileExists(verifyLog) {
+						return fmt.Errorf("%s wasn't rebuilt to verify it", verifyLog)
+					}
+					return nil
+				},
+			),
+		}},
+	})
+}
+
+func TestAccBuildResource_SignProvenanceWithoutKey(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "minimal" {
+	config_contents = file("${path.module}/testdata/minimal.yaml")
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.minimal.config
+	config_contents = data.melange_config.minimal.config_contents
+	force_update    = true
+	sign_provenance = true
+}`,
+			ExpectError: regexp.MustCompile(`sign_provenance is set, but signing key .* doesn't exist`),
+		}},
+	})
+}
+
+func TestAccBuildResource_OfflineGitCheckout(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+provider "melange" {
+	offline = true
+}
+
+data "melange_config" "cloned" {
+	config_contents = <<EOT
+package:
+  name: cloned
+  version: 1.0.0
+  epoch: 0
+environment:
+  contents:
+    packages:
+      - busybox
+pipeline:
+  - uses: git-checkout
+    with:
+      repository: https://github.com/example/cloned
+      tag: v1.0.0
+      expected-commit: 0000000000000000000000000000000000000000
+EOT
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.cloned.config
+	config_contents = data.melange_config.cloned.config_contents
+}`,
+			PlanOnly:    true,
+			ExpectError: regexp.MustCompile(`Package can.t be built offline`),
+		}},
+	})
+}
+
+func TestAccBuildResource_Overrides(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "overrides" {
+	config_contents = file("${path.module}/testdata/overrides.yaml")
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.overrides.config
+	config_contents  = data.melange_config.overrides.config_contents
+	force_update     = true
+	retain_on_delete = true
+
+	vars = { greeting = "howdy" }
+	env  = { NAME = "world" }
+}`,
+			Check: resource.TestCheckResourceAttr("melange_build.build", "vars.greeting", "howdy"),
+		}},
+	})
+
+	_, files, err := readAPK(fmt.Sprintf("packages/%s/overrides-0.0.1-r0.apk", arch), func(name string) bool { return name == "usr/share/greeting.txt" })
+	if err != nil {
+		t.Fatalf("reading apk: %v", err)
+	}
+	if got := string(files["usr/share/greeting.txt"].Data); got != "howdy world\n" {
+		t.Errorf("got greeting %q, want %q", got, "howdy world\n")
+	}
+}
+
+func TestAccBuildResource_Env(t *testing.T) {
+	greeting := func(want string) resource.TestCheckFunc {
+		return func(*terraform.State) error {
+			_, files, err := readAPK(fmt.Sprintf("packages/%s/env-0.0.1-r0.apk", arch), func(name string) bool { return name == "usr/share/greeting.txt" })
+			if err != nil {
+				return err
+			}
+			if got := string(files["usr/share/greeting.txt"].Data); got != want {
+				return fmt.Errorf("got greeting %q, want %q", got, want)
+			}
+			return nil
+		}
+	}
+	redacted := func(*terraform.State) error {
+		b, err := os.ReadFile(fmt.Sprintf("packages/%s/env-0.0.1-r0.log", arch))
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(b), "s3cret") {
+			return fmt.Errorf("build log contains sensitive_env values:\n%s", b)
+		}
+		if !strings.Contains(string(b), "using token <redacted>") {
+			return fmt.Errorf("build log doesn't contain the redacted token:\n%s", b)
+		}
+		return nil
+	}
+	secretNotInState := func(s *terraform.State) error {
+		for k, v := range s.RootModule().Resources["melange_build.build"].Primary.Attributes {
+			if strings.Contains(v, "s3cret") {
+				return fmt.Errorf("state attribute %s contains the value of sensitive_env", k)
+			}
+		}
+		return nil
+	}
+	config := func(greeting string) string {
+		return fmt.Sprintf(`
+data "melange_config" "env" {
+	config_contents = file("${path.module}/testdata/env.yaml")
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.env.config
+	config_contents = data.melange_config.env.config_contents
+
+	env           = { GREETING = %q, NAME = "world" }
+	env_by_arch   = { %s = { NAME = "native" } }
+	sensitive_env = { TOKEN = "MELANGE_TEST_TOKEN" }
+}`, greeting, arch)
+	}
+	t.Setenv("MELANGE_TEST_TOKEN", "s3cret")
+
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: config("hello"),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("melange_build.build", "sensitive_env.TOKEN", "MELANGE_TEST_TOKEN"),
+				secretNotInState,
+				greeting("hello native\n"),
+				redacted,
+			),
+		}, {
+			// Changing the environment rebuilds the package in place.
+			Config: config("howdy"),
+			ConfigPlanChecks: resource.ConfigPlanChecks{
+				PreApply: []plancheck.PlanCheck{plancheck.ExpectResourceAction("melange_build.build", plancheck.ResourceActionUpdate)},
+			},
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("melange_build.build", "planned_actions."+arch, "rebuild"),
+				resource.TestCheckResourceAttr("melange_build.build", "fingerprint_changed", "true"),
+				greeting("howdy native\n"),
+				redacted,
+			),
+		}},
+	})
+}
+
+func TestAccBuildResource_SourceDir(t *testing.T) {
+	src := t.TempDir()
+	write := func(greeting string) {
+		if err := os.WriteFile(filepath.Join(src, "greeting.txt"), []byte(greeting), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	greeting := func(want string) resource.TestCheckFunc {
+		return func(*terraform.State) error {
+			_, files, err := readAPK(fmt.Sprintf("packages/%s/sourced-0.0.1-r0.apk", arch), func(name string) bool { return name == "usr/share/greeting.txt" })
+			if err != nil {
+				return err
+			}
+			if got := string(files["usr/share/greeting.txt"].Data); got != want {
+				return fmt.Errorf("got greeting %q, want %q", got, want)
+			}
+			return nil
+		}
+	}
+	config := fmt.Sprintf(`
+data "melange_config" "sourced" {
+	config_contents = file("${path.module}/testdata/sourced.yaml")
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.sourced.config
+	config_contents = data.melange_config.sourced.config_contents
+	source_dir      = %q
+}`, src)
+
+	write("hello")
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: config,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttrSet("melange_build.build", "source_hash"),
+				greeting("hello"),
+			),
+		}, {
+			// Changing the source replaces the package.
+			PreConfig: func() { write("howdy") },
+			Config:    config,
+			ConfigPlanChecks: resource.ConfigPlanChecks{
+				PreApply: []plancheck.PlanCheck{plancheck.ExpectResourceAction("melange_build.build", plancheck.ResourceActionReplace)},
+			},
+			Check: greeting("howdy"),
+		}},
+	})
+}
+
+func TestAccBuildResource_SourceDirRelativeToConfig(t *testing.T) {
+	dir := t.TempDir()
+	contents, err := os.ReadFile("testdata/sourced.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sourced.yaml"), contents, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "src", "greeting.txt"), []byte("next to the config"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: fmt.Sprintf(`
+data "melange_config" "sourced" {
+	config_file = %q
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.sourced.config
+	config_contents = data.melange_config.sourced.config_contents
+	config_dir      = data.melange_config.sourced.config_dir
+	source_dir      = "src"
+}`, filepath.Join(dir, "sourced.yaml")),
+			Check: func(*terraform.State) error {
+				_, files, err := readAPK(fmt.Sprintf("packages/%s/sourced-0.0.1-r0.apk", arch), func(name string) bool { return name == "usr/share/greeting.txt" })
+				if err != nil {
+					return err
+				}
+				if got, want := string(files["usr/share/greeting.txt"].Data), "next to the config"; got != want {
+					return fmt.Errorf("got greeting %q, want %q", got, want)
+				}
+				return nil
+			},
+		}},
+	})
+}
+
+func TestAccBuildResource_ImplicitSourceDir(t *testing.T) {
+	// The directory named after the package in dir is still copied, but
+	// that's deprecated.
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sourced"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sourced", "greeting.txt"), []byte("implicit"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: fmt.Sprintf(`
+data "melange_config" "sourced" {
+	config_contents = file("${path.module}/testdata/sourced.yaml")
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.sourced.config
+	config_contents = data.melange_config.sourced.config_contents
+	dir             = %q
+}`, dir),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttrSet("melange_build.build", "source_hash"),
+				func(*terraform.State) error {
+					_, files, err := readAPK(filepath.Join(dir, "packages", arch, "sourced-0.0.1-r0.apk"), func(name string) bool { return name == "usr/share/greeting.txt" })
+					if err != nil {
+						return err
+					}
+					if got, want := string(files["usr/share/greeting.txt"].Data), "implicit"; got != want {
+						return fmt.Errorf("got greeting %q, want %q", got, want)
+					}
+					return nil
+				},
+			),
+		}},
+	})
+}
@@ -0,0 +1,300 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+var _ resource.ResourceWithUpgradeState = &BuildResource{}
+
+// buildStateMigrations migrate melange_build's state from each version of
+// its schema to the next: the first from version 0 to 1, and so on. The
+// schema's version is the number of migrations, so changing the shape of an
+// attribute, including config's in configAttributes, means adding one. Each
+// migration reports an error for values it can't convert, rather than
+// dropping them.
+var buildStateMigrations = []func(state map[string]any) error{
+	migrateBuildStateV1,
+}
+
+// migrateBuildStateV1 migrates the state written before the schema was
+// versioned, which has only config, config_contents, force_update and id.
+// Version 1 adds the build's settings and outputs, which are null until the
+// build is next applied, and config's subpackages and what its packages
+// provide. It replaces config's environment, which mirrored apko's image
+// configuration, with the parts of it melange builds use: its contents,
+// accounts, archs and environment variables. The rest, like its entrypoint
+// and os-release, only applies to images, so it's dropped.
+func migrateBuildStateV1(state map[string]any) error {
+	config, err := object(state, "config")
+	if err != nil {
+		return err
+	}
+	addNull(state, "config_dir", "archs", "effective_archs", "apks", "sboms", "provenance",
+		"planned_actions", "planned_apks", "fingerprint_changed", "verify_reproducible",
+		"keep_workspace", "retain_on_delete", "sign_provenance", "extra_repositories",
+		"extra_keyring", "dir", "signing_key", "runner", "namespace", "env", "env_by_arch",
+		"sensitive_env", "env_file", "source_dir", "source_hash", "pipeline_dirs", "vars",
+		"retry", "timeouts")
+	if config == nil {
+		return nil
+	}
+	addNull(config, "subpackages")
+	pkg, err := object(config, "package")
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if pkg != nil {
+		addNull(pkg, "provides", "provider_priority")
+	}
+
+	env, err := object(config, "environment")
+	if err != nil || env == nil {
+		return err
+	}
+	migrated := map[string]any{"archs": env["archs"], "environment": env["environment"]}
+	contents, err := object(env, "contents")
+	if err != nil {
+		return fmt.Errorf("config.environment: %w", err)
+	}
+	if contents != nil {
+		migrated["contents"] = pick(contents, "repositories", "keyring", "packages")
+	}
+	accounts, err := object(env, "accounts")
+	if err != nil {
+		return fmt.Errorf("config.environment: %w", err)
+	}
+	if accounts != nil {
+		users, err := pickEach(accounts["users"], "username", "uid", "gid")
+		if err != nil {
+			return fmt.Errorf("config.environment.accounts.users: %w", err)
+		}
+		groups, err := pickEach(accounts["groups"], "groupname", "gid", "members")
+		if err != nil {
+			return fmt.Errorf("config.environment.accounts.groups: %w", err)
+		}
+		migrated["accounts"] = map[string]any{"run_as": accounts["run-as"], "users": users, "groups": groups}
+	}
+	config["environment"] = migrated
+	return nil
+}
+
+var buildSchemaVersion = int64(len(buildStateMigrations))
+
+func (r *BuildResource) UpgradeState(ctx context.Context) map[int64]resource.StateUpgrader {
+	upgraders := make(map[int64]resource.StateUpgrader, len(buildStateMigrations))
+	for v := range buildStateMigrations {
+		v := int64(v)
+		upgraders[v] = resource.StateUpgrader{
+			StateUpgrader: func(ctx context.Context, req resource.UpgradeStateRequest, resp *resource.UpgradeStateResponse) {
+				if req.RawState == nil || req.RawState.JSON == nil {
+					resp.Diagnostics.AddError("Unable to upgrade state", fmt.Sprintf("state version %d can only be upgraded from JSON", v))
+					return
+				}
+				raw, err := upgradeState(req.RawState.JSON, v, resp.State.Schema.Type().TerraformType(ctx))
+				if err != nil {
+					resp.Diagnostics.AddError("Unable to upgrade state", fmt.Sprintf("upgrading from version %d: %v", v, err))
+					return
+				}
+				resp.State.Raw = raw
+			},
+		}
+	}
+	return upgraders
+}
+
+// upgradeState migrates the JSON state b from version to the current
+// version, then conforms it to typ, the current schema's type.
+func upgradeState(b []byte, version int64, typ tftypes.Type) (tftypes.Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var state map[string]any
+	if err := dec.Decode(&state); err != nil {
+		return tftypes.Value{}, err
+	}
+	for _, migrate := range buildStateMigrations[version:] {
+		if err := migrate(state); err != nil {
+			return tftypes.Value{}, err
+		}
+	}
+	conformed, err := conformJSON(state, typ, "")
+	if err != nil {
+		return tftypes.Value{}, err
+	}
+	b, err = json.Marshal(conformed)
+	if err != nil {
+		return tftypes.Value{}, err
+	}
+	return tfprotov6.RawState{JSON: b}.Unmarshal(typ)
+}
+
+// object returns the object at path in state, or nil if it or any object
+// on the way to it is null.
+func object(state map[string]any, path ...string) (map[string]any, error) {
+	obj := state
+	for i, p := range path {
+		v, ok := obj[p]
+		if !ok || v == nil {
+			return nil, nil
+		}
+		if obj, ok = v.(map[string]any); !ok {
+			return nil, fmt.Errorf("%s: want an object, got %v", strings.Join(path[:i+1], "."), v)
+		}
+	}
+	return obj, nil
+}
+
+// addNull adds each of the attributes names to obj, as null, unless it
+// already has them.
+func addNull(obj map[string]any, names ...string) {
+	for _, n := range names {
+		if _, ok := obj[n]; !ok {
+			obj[n] = nil
+		}
+	}
+}
+
+// pick returns the attributes names of obj.
+func pick(obj map[string]any, names ...string) map[string]any {
+	out := make(map[string]any, len(names))
+	for _, n := range names {
+		out[n] = obj[n]
+	}
+	return out
+}
+
+// pickEach returns the attributes names of each object in the list v.
+func pickEach(v any, names ...string) ([]any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("want a list, got %v", v)
+	}
+	out := make([]any, 0, len(list))
+	for i, e := range list {
+		obj, ok := e.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%d: want an object, got %v", i, e)
+		}
+		out = append(out, pick(obj, names...))
+	}
+	return out, nil
+}
+
+// conformJSON converts the decoded JSON value v, at path in the state, to
+// typ. Strings, numbers and bools are converted between where the conversion
+// is lossless, and object attributes the state doesn't have are null. Any
+// other mismatch, including an attribute typ doesn't have, is an error: it's
+// a change of the schema without a migration.
+func conformJSON(v any, typ tftypes.Type, path string) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch t := typ.(type) {
+	case tftypes.Object:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: want an object, got %v", path, v)
+		}
+		out := make(map[string]any, len(m))
+		for k, av := range m {
+			attrType, ok := t.AttributeTypes[k]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown attribute", joinPath(path, k))
+			}
+			cv, err := conformJSON(av, attrType, joinPath(path, k))
+			if err != nil {
+				return nil, err
+			}
+			out[k] = cv
+		}
+		return out, nil
+	case tftypes.List:
+		return conformElements(v, t.ElementType, path)
+	case tftypes.Set:
+		return conformElements(v, t.ElementType, path)
+	case tftypes.Map:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: want a map, got %v", path, v)
+		}
+		out := make(map[string]any, len(m))
+		for k, ev := range m {
+			cv, err := conformJSON(ev, t.ElementType, joinPath(path, k))
+			if err != nil {
+				return nil, err
+			}
+			out[k] = cv
+		}
+		return out, nil
+	}
+
+	switch {
+	case typ.Is(tftypes.String):
+		switch p := v.(type) {
+		case string:
+			return p, nil
+		case json.Number:
+			return p.String(), nil
+		case bool:
+			return strconv.FormatBool(p), nil
+		}
+	case typ.Is(tftypes.Number):
+		switch p := v.(type) {
+		case json.Number:
+			return p, nil
+		case string:
+			if _, err := strconv.ParseFloat(p, 64); err == nil {
+				return json.Number(p), nil
+			}
+		}
+	case typ.Is(tftypes.Bool):
+		switch p := v.(type) {
+		case bool:
+			return p, nil
+		case string:
+			if b, err := strconv.ParseBool(p); err == nil {
+				return b, nil
+			}
+		}
+	default:
+		return v, nil
+	}
+	return nil, fmt.Errorf("%s: can't convert %v to %s", path, v, typ)
+}
+
+func conformElements(v any, elemType tftypes.Type, path string) (any, error) {
+	s, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: want a list, got %v", path, v)
+	}
+	out := make([]any, 0, len(s))
+	for i, ev := range s {
+		cv, err := conformJSON(ev, elemType, joinPath(path, strconv.Itoa(i)))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, cv)
+	}
+	return out, nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,148 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+)
+
+func TestUpgradeBuildState(t *testing.T) {
+	ctx := context.Background()
+	r := &BuildResource{}
+	var schemaResp resource.SchemaResponse
+	r.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	upgraders := r.UpgradeState(ctx)
+	for v := int64(0); v < schemaResp.Schema.Version; v++ {
+		if _, ok := upgraders[v]; !ok {
+			t.Errorf("no upgrader from version %d", v)
+		}
+	}
+
+	for _, c := range []struct {
+		fixture string
+		version int64
+		check   func(*testing.T, BuildResourceModel, configModel)
+	}{{
+		// State the first releases wrote for testdata/minimal.yaml on arm64,
+		// with config mirroring apko's image configuration. Its id is the one
+		// their acceptance test expected.
+		fixture: "build-v0.json",
+		version: 0,
+		check: func(t *testing.T, data BuildResourceModel, cfg configModel) {
+			if got, want := data.Id.ValueString(), "100ffaf3d06713d2737fdcbbb2176ba96161671fac4cc2d1b84000edffd187f3"; got != want {
+				t.Errorf("id = %q, want %q", got, want)
+			}
+			if got, want := *cfg.Package, (packageModel{Name: types.StringValue("minimal"), Version: types.StringValue("0.0.1"), Epoch: types.Int64Value(3), ProviderPriority: types.Int64Null()}); !reflect.DeepEqual(got, want) {
+				t.Errorf("config.package = %+v, want %+v", got, want)
+			}
+			contents := cfg.Environment.Contents
+			if got, want := contents.Packages, []string{"busybox"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("config.environment.contents.packages = %v, want %v", got, want)
+			}
+			if got, want := contents.Repositories, []string{"https://packages.wolfi.dev/os"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("config.environment.contents.repositories = %v, want %v", got, want)
+			}
+			if got, want := cfg.Environment.Archs, []string{"arm64"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("config.environment.archs = %v, want %v", got, want)
+			}
+			if got := cfg.Environment.Accounts; got.RunAs.ValueString() != "" || len(got.Users) != 0 || len(got.Groups) != 0 {
+				t.Errorf("config.environment.accounts = %+v, want none", got)
+			}
+			if !data.EffectiveArchs.IsNull() || !data.SourceHash.IsNull() || !data.APKs.IsNull() || cfg.Subpackages != nil {
+				t.Errorf("attributes added since should be null, got effective_archs %v, source_hash %v, apks %v and config.subpackages %v", data.EffectiveArchs, data.SourceHash, data.APKs, cfg.Subpackages)
+			}
+		},
+	}, {
+		// State the first releases wrote for a package built as another
+		// user, on arm64.
+		fixture: "build-v0-accounts.json",
+		version: 0,
+		check: func(t *testing.T, data BuildResourceModel, cfg configModel) {
+			env := cfg.Environment
+			if got, want := env.Accounts.RunAs.ValueString(), "build"; got != want {
+				t.Errorf("config.environment.accounts.run_as = %q, want %q", got, want)
+			}
+			if got, want := env.Accounts.Users, []userModel{{Username: types.StringValue("build"), UID: types.Int64Value(1000), GID: types.Int64Value(1000)}}; !reflect.DeepEqual(got, want) {
+				t.Errorf("config.environment.accounts.users = %+v, want %+v", got, want)
+			}
+			if got, want := env.Accounts.Groups, []groupModel{{Groupname: types.StringValue("build"), GID: types.Int64Value(1000), Members: []string{"build"}}}; !reflect.DeepEqual(got, want) {
+				t.Errorf("config.environment.accounts.groups = %+v, want %+v", got, want)
+			}
+			if got, want := env.Environment, map[string]string{"LANG": "C.UTF-8"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("config.environment.environment = %v, want %v", got, want)
+			}
+		},
+	}} {
+		t.Run(c.fixture, func(t *testing.T) {
+			b, err := os.ReadFile(filepath.Join("testdata", "state", c.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := resource.UpgradeStateResponse{State: tfsdk.State{Schema: schemaResp.Schema}}
+			upgraders[c.version].StateUpgrader(ctx, resource.UpgradeStateRequest{RawState: &tfprotov6.RawState{JSON: b}}, &resp)
+			if resp.Diagnostics.HasError() {
+				t.Fatalf("upgrading state: %v", resp.Diagnostics)
+			}
+			var data BuildResourceModel
+			if diags := resp.State.Get(ctx, &data); diags.HasError() {
+				t.Fatalf("reading upgraded state: %v", diags)
+			}
+			cfg, err := parseConfigValue(ctx, data.Config)
+			if err != nil {
+				t.Fatalf("reading upgraded config: %v", err)
+			}
+			c.check(t, data, cfg)
+		})
+	}
+}
+
+func TestUpgradeBuildState_Errors(t *testing.T) {
+	ctx := context.Background()
+	var schemaResp resource.SchemaResponse
+	(&BuildResource{}).Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	typ := schemaResp.Schema.Type().TerraformType(ctx)
+
+	for _, c := range []struct {
+		desc, state string
+		version     int64
+		want        string
+	}{{
+		desc:    "config isn't an object",
+		state:   `{"config": "minimal", "id": "x"}`,
+		version: 0,
+		want:    "config: want an object",
+	}, {
+		desc:    "users aren't objects",
+		state:   `{"config": {"environment": {"accounts": {"users": ["build"]}}}}`,
+		version: 0,
+		want:    "config.environment.accounts.users: 0: want an object",
+	}, {
+		desc:    "epoch isn't a number",
+		state:   `{"config": {"package": {"name": "minimal", "epoch": "three"}}}`,
+		version: 0,
+		want:    "config.package.epoch: can't convert three",
+	}, {
+		desc:    "attribute added without a migration",
+		state:   `{"config": {"package": {"name": "minimal", "origin": "minimal"}}}`,
+		version: 1,
+		want:    "config.package.origin: unknown attribute",
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			_, err := upgradeState([]byte(c.state), c.version, typ)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("upgradeState() = %v, want an error containing %q", err, c.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,19 @@
 package provider
 
-import apko_types "chainguard.dev/apko/pkg/build/types"
+import (
+	"context"
+	"fmt"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	melangeconfig "chainguard.dev/melange/pkg/config"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	dschema "github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
 
 type Package struct {
 	// The name of the package
@@ -11,10 +24,285 @@ type Package struct {
 	Epoch uint32 `yaml:"epoch"`
 }
 
-// The root melange configuration
+// The root melange configuration, as parsed from YAML
 type Configuration struct {
 	// Package metadata
 	Package Package `yaml:"package"`
 	// The specification for the packages build environment
-	Environment apko_types.ImageConfiguration
+	Environment apko_types.ImageConfiguration `yaml:"environment"`
+}
+
+// configAttribute describes an attribute of the config object exposed by
+// melange_config and accepted by melange_build and melange_graph. It's either
+// a leaf of type typ, or a nested object with attributes, or a list of them.
+type configAttribute struct {
+	description string
+	typ         attr.Type
+	attributes  map[string]configAttribute
+	list        bool
+}
+
+// configAttributes is the config object's schema: the package's identity, its
+// subpackages, and the parts of apko's image configuration melange builds
+// packages in.
+// Changing it changes the shape of melange_build's state, so it needs a
+// migration in buildStateMigrations.
+var configAttributes = map[string]configAttribute{
+	"package": {
+		description: "The package's identity, and the names it provides.",
+		attributes: map[string]configAttribute{
+			"name":              {description: "The name of the package.", typ: types.StringType},
+			"version":           {description: "The version of the package.", typ: types.StringType},
+			"epoch":             {description: "The monotonically increasing epoch of the package, bumped to rebuild the same version.", typ: types.Int64Type},
+			"provides":          {description: "The names the package provides besides its own, with their versions substituted, like `openssl=3.2.0-r0`.", typ: types.ListType{ElemType: types.StringType}},
+			"provider_priority": {description: "The package's `provider-priority`, which decides between packages providing the same name.", typ: types.Int64Type},
+		},
+	},
+	"subpackages": {
+		description: "The subpackages built alongside the package, with their names substituted.",
+		list:        true,
+		attributes: map[string]configAttribute{
+			"name":              {description: "The name of the subpackage.", typ: types.StringType},
+			"provides":          {description: "The names the subpackage provides besides its own, with their versions substituted.", typ: types.ListType{ElemType: types.StringType}},
+			"provider_priority": {description: "The subpackage's `provider-priority`.", typ: types.Int64Type},
+		},
+	},
+	"environment": {
+		description: "The environment the package is built in.",
+		attributes: map[string]configAttribute{
+			"contents": {
+				description: "The packages installed in the build environment, and where they come from.",
+				attributes: map[string]configAttribute{
+					"repositories": {description: "The repositories to install packages from, including the provider's.", typ: types.ListType{ElemType: types.StringType}},
+					"keyring":      {description: "The keys to verify packages with, including the provider's.", typ: types.ListType{ElemType: types.StringType}},
+					"packages":     {description: "The packages to install.", typ: types.ListType{ElemType: types.StringType}},
+				},
+			},
+			"accounts": {
+				description: "The users and groups in the build environment.",
+				attributes: map[string]configAttribute{
+					"run_as": {description: "The user the build runs as.", typ: types.StringType},
+					"users": {
+						description: "The users to create.",
+						list:        true,
+						attributes: map[string]configAttribute{
+							"username": {description: "The user's name.", typ: types.StringType},
+							"uid":      {description: "The user's ID.", typ: types.Int64Type},
+							"gid":      {description: "The ID of the user's primary group.", typ: types.Int64Type},
+						},
+					},
+					"groups": {
+						description: "The groups to create.",
+						list:        true,
+						attributes: map[string]configAttribute{
+							"groupname": {description: "The group's name.", typ: types.StringType},
+							"gid":       {description: "The group's ID.", typ: types.Int64Type},
+							"members":   {description: "The names of the group's members.", typ: types.ListType{ElemType: types.StringType}},
+						},
+					},
+				},
+			},
+			"archs":       {description: "The architectures the package is built for.", typ: types.ListType{ElemType: types.StringType}},
+			"environment": {description: "Environment variables set in the build.", typ: types.MapType{ElemType: types.StringType}},
+		},
+	},
+}
+
+// configSchema is the type of the config object.
+var configSchema = configObjectType(configAttributes)
+
+func configObjectType(attrs map[string]configAttribute) basetypes.ObjectType {
+	attrTypes := make(map[string]attr.Type, len(attrs))
+	for name, a := range attrs {
+		attrTypes[name] = a.attrType()
+	}
+	return basetypes.ObjectType{AttrTypes: attrTypes}
+}
+
+func (a configAttribute) attrType() attr.Type {
+	switch {
+	case a.attributes == nil:
+		return a.typ
+	case a.list:
+		return basetypes.ListType{ElemType: configObjectType(a.attributes)}
+	default:
+		return configObjectType(a.attributes)
+	}
+}
+
+// resourceConfigAttributes returns attrs as optional resource attributes,
+// so config objects with null attributes can be passed to resources.
+func resourceConfigAttributes(attrs map[string]configAttribute) map[string]rschema.Attribute {
+	out := make(map[string]rschema.Attribute, len(attrs))
+	for name, a := range attrs {
+		switch {
+		case a.attributes != nil && a.list:
+			out[name] = rschema.ListNestedAttribute{MarkdownDescription: a.description, Optional: true,
+				NestedObject: rschema.NestedAttributeObject{Attributes: resourceConfigAttributes(a.attributes)}}
+		case a.attributes != nil:
+			out[name] = rschema.SingleNestedAttribute{MarkdownDescription: a.description, Optional: true,
+				Attributes: resourceConfigAttributes(a.attributes)}
+		default:
+			switch t := a.typ.(type) {
+			case basetypes.StringType:
+				out[name] = rschema.StringAttribute{MarkdownDescription: a.description, Optional: true}
+			case basetypes.Int64Type:
+				out[name] = rschema.Int64Attribute{MarkdownDescription: a.description, Optional: true}
+			case basetypes.ListType:
+				out[name] = rschema.ListAttribute{MarkdownDescription: a.description, Optional: true, ElementType: t.ElemType}
+			case basetypes.MapType:
+				out[name] = rschema.MapAttribute{MarkdownDescription: a.description, Optional: true, ElementType: t.ElemType}
+			}
+		}
+	}
+	return out
+}
+
+// dataSourceConfigAttributes returns attrs as data source attributes, which
+// are computed if computed is set, and optional otherwise.
+func dataSourceConfigAttributes(attrs map[string]configAttribute, computed bool) map[string]dschema.Attribute {
+	optional := !computed
+	out := make(map[string]dschema.Attribute, len(attrs))
+	for name, a := range attrs {
+		switch {
+		case a.attributes != nil && a.list:
+			out[name] = dschema.ListNestedAttribute{MarkdownDescription: a.description, Optional: optional, Computed: computed,
+				NestedObject: dschema.NestedAttributeObject{Attributes: dataSourceConfigAttributes(a.attributes, computed)}}
+		case a.attributes != nil:
+			out[name] = dschema.SingleNestedAttribute{MarkdownDescription: a.description, Optional: optional, Computed: computed,
+				Attributes: dataSourceConfigAttributes(a.attributes, computed)}
+		default:
+			switch t := a.typ.(type) {
+			case basetypes.StringType:
+				out[name] = dschema.StringAttribute{MarkdownDescription: a.description, Optional: optional, Computed: computed}
+			case basetypes.Int64Type:
+				out[name] = dschema.Int64Attribute{MarkdownDescription: a.description, Optional: optional, Computed: computed}
+			case basetypes.ListType:
+				out[name] = dschema.ListAttribute{MarkdownDescription: a.description, Optional: optional, Computed: computed, ElementType: t.ElemType}
+			case basetypes.MapType:
+				out[name] = dschema.MapAttribute{MarkdownDescription: a.description, Optional: optional, Computed: computed, ElementType: t.ElemType}
+			}
+		}
+	}
+	return out
+}
+
+// configModel is the config object, converted to and from Configuration.
+type configModel struct {
+	Package     *packageModel     `tfsdk:"package"`
+	Subpackages []subpackageModel `tfsdk:"subpackages"`
+	Environment *environmentModel `tfsdk:"environment"`
+}
+
+type packageModel struct {
+	Name             types.String `tfsdk:"name"`
+	Version          types.String `tfsdk:"version"`
+	Epoch            types.Int64  `tfsdk:"epoch"`
+	Provides         []string     `tfsdk:"provides"`
+	ProviderPriority types.Int64  `tfsdk:"provider_priority"`
+}
+
+type subpackageModel struct {
+	Name             types.String `tfsdk:"name"`
+	Provides         []string     `tfsdk:"provides"`
+	ProviderPriority types.Int64  `tfsdk:"provider_priority"`
+}
+
+type environmentModel struct {
+	Contents    *contentsModel    `tfsdk:"contents"`
+	Accounts    *accountsModel    `tfsdk:"accounts"`
+	Archs       []string          `tfsdk:"archs"`
+	Environment map[string]string `tfsdk:"environment"`
+}
+
+type contentsModel struct {
+	Repositories []string `tfsdk:"repositories"`
+	Keyring      []string `tfsdk:"keyring"`
+	Packages     []string `tfsdk:"packages"`
+}
+
+type accountsModel struct {
+	RunAs  types.String `tfsdk:"run_as"`
+	Users  []userModel  `tfsdk:"users"`
+	Groups []groupModel `tfsdk:"groups"`
+}
+
+type userModel struct {
+	Username types.String `tfsdk:"username"`
+	UID      types.Int64  `tfsdk:"uid"`
+	GID      types.Int64  `tfsdk:"gid"`
+}
+
+type groupModel struct {
+	Groupname types.String `tfsdk:"groupname"`
+	GID       types.Int64  `tfsdk:"gid"`
+	Members   []string     `tfsdk:"members"`
+}
+
+// configValue returns the value of the config attribute for the parsed
+// configuration, with the provider's repositories and keys added and its
+// architectures set to archs. Subpackages and what packages provide come
+// from parsed, melange's parse of the same configuration, which expands
+// subpackage ranges and substitutes names and versions.
+func (o ProviderOpts) configValue(ctx context.Context, cfg Configuration, parsed *melangeconfig.Configuration, archs []apko_types.Architecture) (basetypes.ObjectValue, diag.Diagnostics) {
+	env := cfg.Environment
+	m := configModel{
+		Package: &packageModel{
+			Name:             types.StringValue(cfg.Package.Name),
+			Version:          types.StringValue(cfg.Package.Version),
+			Epoch:            types.Int64Value(int64(cfg.Package.Epoch)),
+			Provides:         parsed.Package.Dependencies.Provides,
+			ProviderPriority: types.Int64Value(int64(parsed.Package.Dependencies.ProviderPriority)),
+		},
+		Environment: &environmentModel{
+			Contents: &contentsModel{
+				Repositories: sets.List(sets.New(env.Contents.Repositories...).Insert(o.repositories...)),
+				Keyring:      sets.List(sets.New(env.Contents.Keyring...).Insert(o.keyring...)),
+				Packages:     env.Contents.Packages,
+			},
+			Accounts:    &accountsModel{RunAs: types.StringValue(env.Accounts.RunAs)},
+			Environment: env.Environment,
+		},
+	}
+	for _, sp := range parsed.Subpackages {
+		m.Subpackages = append(m.Subpackages, subpackageModel{
+			Name:             types.StringValue(sp.Name),
+			Provides:         sp.Dependencies.Provides,
+			ProviderPriority: types.Int64Value(int64(sp.Dependencies.ProviderPriority)),
+		})
+	}
+	for _, u := range env.Accounts.Users {
+		m.Environment.Accounts.Users = append(m.Environment.Accounts.Users, userModel{
+			Username: types.StringValue(u.UserName),
+			UID:      types.Int64Value(int64(u.UID)),
+			GID:      types.Int64Value(int64(u.GID)),
+		})
+	}
+	for _, g := range env.Accounts.Groups {
+		m.Environment.Accounts.Groups = append(m.Environment.Accounts.Groups, groupModel{
+			Groupname: types.StringValue(g.GroupName),
+			GID:       types.Int64Value(int64(g.GID)),
+			Members:   g.Members,
+		})
+	}
+	for _, a := range archs {
+		m.Environment.Archs = append(m.Environment.Archs, a.String())
+	}
+	return types.ObjectValueFrom(ctx, configSchema.AttrTypes, m)
+}
+
+// parseConfigValue converts the config attribute back into the parts of the
+// configuration the provider uses.
+func parseConfigValue(ctx context.Context, v types.Object) (configModel, error) {
+	var m configModel
+	if diags := v.As(ctx, &m, basetypes.ObjectAsOptions{}); diags.HasError() {
+		return configModel{}, fmt.Errorf("reading config: %v", diags.Errors())
+	}
+	if m.Package == nil {
+		m.Package = &packageModel{}
+	}
+	if m.Environment == nil {
+		m.Environment = &environmentModel{}
+	}
+	return m, nil
 }
@@ -7,27 +7,19 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
 
-	apkotypes "chainguard.dev/apko/pkg/build/types"
-	"github.com/chainguard-dev/terraform-provider-apko/reflect"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
 	"gopkg.in/yaml.v2"
-	"k8s.io/apimachinery/pkg/util/sets"
 )
 
-var configSchema basetypes.ObjectType
-
-func init() {
-	sch, err := reflect.GenerateType(Configuration{})
-	if err != nil {
-		panic(err)
-	}
-	configSchema = sch.(basetypes.ObjectType)
-}
-
 // Ensure provider defined types fully satisfy framework interfaces.
 var _ datasource.DataSource = &ConfigDataSource{}
 
@@ -43,7 +35,17 @@ type ConfigDataSource struct {
 // ConfigDataSourceModel describes the data source data model.
 type ConfigDataSourceModel struct {
 	ConfigContents types.String `tfsdk:"config_contents"`
+	ConfigFile     types.String `tfsdk:"config_file"`
+	ConfigPath     types.String `tfsdk:"config_path"`
+	ConfigDir      types.String `tfsdk:"config_dir"`
 	Config         types.Object `tfsdk:"config"`
+	LintLevel      types.String `tfsdk:"lint_level"`
+	LintRules      types.Map    `tfsdk:"lint_rules"`
+	LintFindings   types.List   `tfsdk:"lint_findings"`
+	Archs          types.List   `tfsdk:"archs"`
+	EffectiveArchs types.List   `tfsdk:"effective_archs"`
+	Render         types.Bool   `tfsdk:"render"`
+	Rendered       types.Map    `tfsdk:"rendered"`
 	Id             types.String `tfsdk:"id"`
 }
 
@@ -58,13 +60,59 @@ func (d *ConfigDataSource) Schema(ctx context.Context, req datasource.SchemaRequ
 
 		Attributes: map[string]schema.Attribute{
 			"config_contents": schema.StringAttribute{
-				MarkdownDescription: "The raw contents of the melange configuration.",
+				MarkdownDescription: "The raw contents of the melange configuration. Exactly one of `config_contents` or `config_file` must be set.",
+				Optional:            true,
+				Computed:            true,
+			},
+			"config_file": schema.StringAttribute{
+				MarkdownDescription: "Path to a file containing the melange configuration. Local pipelines and source directories are resolved relative to it.",
 				Optional:            true,
 			},
-			"config": schema.ObjectAttribute{
+			"config_path": schema.StringAttribute{
+				MarkdownDescription: "The absolute path of `config_file`, if set.",
+				Computed:            true,
+			},
+			"config_dir": schema.StringAttribute{
+				MarkdownDescription: "The directory containing `config_file`, if set. Pass this to `melange_build` to resolve local pipelines and source directories relative to the config.",
+				Computed:            true,
+			},
+			"config": schema.SingleNestedAttribute{
 				MarkdownDescription: "The parsed structure of the melange configuration.",
 				Computed:            true,
-				AttributeTypes:      configSchema.AttrTypes,
+				Attributes:          dataSourceConfigAttributes(configAttributes, true),
+			},
+			"lint_level": schema.StringAttribute{
+				MarkdownDescription: "How to report lint findings: `warn` (the default), `error`, or `off`.",
+				Optional:            true,
+			},
+			"lint_rules": schema.MapAttribute{
+				MarkdownDescription: "Lint rules to enable or disable, by name. All rules are enabled by default.",
+				Optional:            true,
+				ElementType:         basetypes.BoolType{},
+			},
+			"lint_findings": schema.ListAttribute{
+				MarkdownDescription: "Problems found by the enabled lint rules.",
+				Computed:            true,
+				ElementType:         lintFindingType,
+			},
+			"archs": schema.ListAttribute{
+				MarkdownDescription: "Architectures to build for, overriding the configuration and the provider's `default_archs`. Architectures not in the configuration's `target-architecture` are ignored.",
+				Optional:            true,
+				ElementType:         basetypes.StringType{},
+			},
+			"effective_archs": schema.ListAttribute{
+				MarkdownDescription: "The architectures the package will be built for: `archs` if set, otherwise the configuration's `target-architecture`, then its `environment.archs`, then the provider's `default_archs`.",
+				Computed:            true,
+				ElementType:         basetypes.StringType{},
+			},
+			"render": schema.BoolAttribute{
+				MarkdownDescription: "Whether to populate `rendered`.",
+				Optional:            true,
+			},
+			"rendered": schema.MapAttribute{
+				MarkdownDescription: "The configuration's variables and pipeline steps for each architecture, with melange substitutions and var-transforms evaluated as they would be at build time. Only set if `render` is true.",
+				Computed:            true,
+				ElementType:         renderedType,
 			},
 			"id": schema.StringAttribute{
 				MarkdownDescription: "Config identifier",
@@ -95,23 +143,135 @@ func (d *ConfigDataSource) Read(ctx context.Context, req datasource.ReadRequest,
 		return
 	}
 
+	// Problems with the configuration are reported against whichever
+	// attribute it came from.
+	src := path.Root("config_contents")
+	switch {
+	case data.ConfigFile.IsNull() == data.ConfigContents.IsNull():
+		resp.Diagnostics.AddError("Invalid melange configuration", "exactly one of config_contents or config_file must be set")
+		return
+	case !data.ConfigFile.IsNull():
+		abs, err := filepath.Abs(data.ConfigFile.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("config_file"), "Unable to resolve config file", err.Error())
+			return
+		}
+		b, err := os.ReadFile(abs)
+		if err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("config_file"), "Unable to read config file", err.Error())
+			return
+		}
+		data.ConfigContents = types.StringValue(string(b))
+		data.ConfigPath = types.StringValue(abs)
+		data.ConfigDir = types.StringValue(filepath.Dir(abs))
+		src = path.Root("config_file")
+	default:
+		data.ConfigPath = types.StringNull()
+		data.ConfigDir = types.StringNull()
+	}
+
+	// Strictly validate the config first, so typos and invalid values are
+	// reported with their location instead of being silently ignored.
+	if errs := validateConfig([]byte(data.ConfigContents.ValueString()), d.popts.pipelineDir(data.ConfigDir.ValueString())); len(errs) > 0 {
+		for _, err := range errs {
+			resp.Diagnostics.AddAttributeError(src, "Invalid melange configuration", err.Error())
+		}
+		return
+	}
+
+	level := data.LintLevel.ValueString()
+	switch level {
+	case "":
+		level = "warn"
+	case "warn", "error", "off":
+	default:
+		resp.Diagnostics.AddAttributeError(path.Root("lint_level"), "Invalid lint level", fmt.Sprintf("%q must be one of warn, error or off", level))
+		return
+	}
+	rules := map[string]bool{}
+	resp.Diagnostics.Append(data.LintRules.ElementsAs(ctx, &rules, false)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	for name := range rules {
+		if _, ok := lintRules[name]; !ok {
+			resp.Diagnostics.AddAttributeError(path.Root("lint_rules"), "Unknown lint rule", fmt.Sprintf("%q is not a known lint rule", name))
+		}
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var findings []attr.Value
+	if level != "off" {
+		for _, f := range lintConfig([]byte(data.ConfigContents.ValueString()), rules) {
+			summary, detail := fmt.Sprintf("Lint: %s", f.Rule), f.Error()
+			if level == "error" {
+				resp.Diagnostics.AddAttributeError(src, summary, detail)
+			} else {
+				resp.Diagnostics.AddAttributeWarning(src, summary, detail)
+			}
+			findings = append(findings, f.value())
+		}
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	lv, diags := basetypes.NewListValue(lintFindingType, findings)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+	data.LintFindings = lv
+
 	var cfg Configuration
 	if err := yaml.Unmarshal([]byte(data.ConfigContents.ValueString()), &cfg); err != nil {
 		resp.Diagnostics.AddError("Unable to parse melange configuration", err.Error())
 		return
 	}
 
-	// Append any provider-specified repositories and keys, if specified.
-	cfg.Environment.Contents.Repositories = sets.List(sets.New(cfg.Environment.Contents.Repositories...).Insert(d.popts.repositories...))
-	cfg.Environment.Contents.Keyring = sets.List(sets.New(cfg.Environment.Contents.Keyring...).Insert(d.popts.keyring...))
-	cfg.Environment.Archs = apkotypes.ParseArchitectures(d.popts.archs)
+	var override []string
+	resp.Diagnostics.Append(data.Archs.ElementsAs(ctx, &override, false)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	targets, err := targetArchitectures([]byte(data.ConfigContents.ValueString()))
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(src, "Unable to parse melange configuration", err.Error())
+		return
+	}
+	configArchs := make([]string, 0, len(cfg.Environment.Archs))
+	for _, a := range cfg.Environment.Archs {
+		configArchs = append(configArchs, a.String())
+	}
+	archs, err := effectiveArchs(targets, override, configArchs, d.popts.archs)
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("archs"), "Invalid architectures", err.Error())
+		return
+	}
+	data.EffectiveArchs = archsValue(archs)
+
+	data.Rendered = basetypes.NewMapNull(renderedType)
+	if data.Render.ValueBool() {
+		rendered, err := renderConfig([]byte(data.ConfigContents.ValueString()), archs)
+		if err != nil {
+			resp.Diagnostics.AddAttributeError(src, "Unable to render melange configuration", err.Error())
+			return
+		}
+		data.Rendered = rendered
+	}
 
-	ov, diags := reflect.GenerateValue(cfg)
+	parsed, err := parseMelangeConfig([]byte(data.ConfigContents.ValueString()))
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(src, "Unable to parse melange configuration", err.Error())
+		return
+	}
+	ov, diags := d.popts.configValue(ctx, cfg, parsed, archs)
 	resp.Diagnostics = append(resp.Diagnostics, diags...)
 	if diags.HasError() {
 		return
 	}
-	data.Config = ov.(basetypes.ObjectValue)
+	data.Config = ov
 
 	hash := sha256.Sum256([]byte(data.ConfigContents.ValueString()))
 	data.Id = types.StringValue(hex.EncodeToString(hash[:]))
@@ -4,6 +4,7 @@
 package provider
 
 import (
+	"regexp"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
@@ -42,6 +43,74 @@ EOF
 				resource.TestCheckResourceAttr("data.melange_config.minimal", "config.environment.contents.packages.#", "2"),
 				resource.TestCheckResourceAttr("data.melange_config.minimal", "config.environment.contents.packages.0", "alpine-baselayout-data"),
 				resource.TestCheckResourceAttr("data.melange_config.minimal", "config.environment.contents.packages.1", "busybox"),
+				resource.TestCheckResourceAttr("data.melange_config.minimal", "lint_findings.#", "1"),
+				resource.TestCheckResourceAttr("data.melange_config.minimal", "lint_findings.0.rule", "copyright"),
+			),
+		}},
+	})
+}
+
+func TestAccConfigDataSource_Invalid(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "typo" {
+	config_contents = <<EOF
+package:
+  name: typo
+  version: 0.0.1
+  epoch: 0
+enviroment:
+  contents:
+    packages:
+      - busybox
+EOF
+}`,
+			ExpectError: regexp.MustCompile(`enviroment, line 5, column 1: unknown field "enviroment"`),
+		}},
+	})
+}
+
+func TestAccConfigDataSource_LintError(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "lint" {
+	lint_level      = "error"
+	lint_rules      = { copyright = false }
+	config_contents = <<EOF
+package:
+  name: lint
+  version: 0.0.1
+  epoch: 0
+  description: fetches without a checksum
+pipeline:
+  - uses: fetch
+    with:
+      uri: https://example.com/lint-0.0.1.tar.gz
+EOF
+}`,
+			ExpectError: regexp.MustCompile(`fetch has no expected-sha256 or expected-sha512`),
+		}},
+	})
+}
+
+func TestAccConfigDataSource_File(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "minimal" {
+	config_file = "testdata/minimal.yaml"
+}`,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.melange_config.minimal", "config.package.name", "minimal"),
+				resource.TestCheckResourceAttr("data.melange_config.minimal", "config.package.epoch", "3"),
+				resource.TestMatchResourceAttr("data.melange_config.minimal", "config_path", regexp.MustCompile(`/testdata/minimal\.yaml$`)),
+				resource.TestMatchResourceAttr("data.melange_config.minimal", "config_dir", regexp.MustCompile(`/testdata$`)),
+				resource.TestMatchResourceAttr("data.melange_config.minimal", "config_contents", regexp.MustCompile(`name: minimal`)),
 			),
 		}},
 	})
@@ -0,0 +1,198 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	apkolog "chainguard.dev/apko/pkg/log"
+	"chainguard.dev/melange/pkg/build"
+	"github.com/google/go-containerregistry/pkg/logs"
+)
+
+// redacted replaces sensitive values in build logs.
+const redacted = "<redacted>"
+
+// buildEnv returns the inline environment variables for a build for arch:
+// env, overlaid with env_by_arch[arch], then sensitive_env's values.
+func buildEnv(ctx context.Context, data BuildResourceModel, arch string) (map[string]string, error) {
+	var env map[string]string
+	var byArch map[string]map[string]string
+	if diags := data.Env.ElementsAs(ctx, &env, false); diags.HasError() {
+		return nil, fmt.Errorf("reading env: %v", diags.Errors())
+	}
+	if diags := data.EnvByArch.ElementsAs(ctx, &byArch, false); diags.HasError() {
+		return nil, fmt.Errorf("reading env_by_arch: %v", diags.Errors())
+	}
+	sensitive, err := sensitiveEnv(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	merged := map[string]string{}
+	for _, m := range []map[string]string{env, byArch[arch], sensitive} {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+// sensitiveEnv returns the variables of sensitive_env, with the values of
+// the provider's environment variables they name. Only the names are in the
+// state.
+func sensitiveEnv(ctx context.Context, data BuildResourceModel) (map[string]string, error) {
+	var names map[string]string
+	if diags := data.SensitiveEnv.ElementsAs(ctx, &names, false); diags.HasError() {
+		return nil, fmt.Errorf("reading sensitive_env: %v", diags.Errors())
+	}
+	env := make(map[string]string, len(names))
+	for k, name := range names {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("sensitive_env.%s: %s isn't set in the provider's environment", k, name)
+		}
+		env[k] = v
+	}
+	return env, nil
+}
+
+// sensitiveValues returns the non-empty values of sensitive_env.
+func sensitiveValues(ctx context.Context, data BuildResourceModel) ([]string, error) {
+	env, err := sensitiveEnv(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, v := range env {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// buildContents returns the configuration melange builds: config_contents
+// without its test section, with vars applied.
+func buildContents(ctx context.Context, data BuildResourceModel) ([]byte, error) {
+	contents, _, err := splitTestSection([]byte(data.ConfigContents.ValueString()))
+	if err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+	var vars map[string]string
+	if diags := data.Vars.ElementsAs(ctx, &vars, false); diags.HasError() {
+		return nil, fmt.Errorf("reading vars: %v", diags.Errors())
+	}
+	if contents, err = withVars(contents, vars); err != nil {
+		return nil, fmt.Errorf("setting vars: %w", err)
+	}
+	return contents, nil
+}
+
+// fingerprint returns the sha256 of the configuration melange builds, the
+// source directory's hash and the inline environment, which identifies the
+// build: the resource is updated, and its packages rebuilt, when it changes.
+//
+// It's computed from the configuration's YAML rather than config, so that it
+// doesn't change with config's schema. Of sensitive_env, only the names are
+// included, so changing a value doesn't rebuild the package.
+func fingerprint(ctx context.Context, data BuildResourceModel) (string, error) {
+	contents, err := buildContents(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	b, _ := json.Marshal(string(contents))
+	fmt.Fprintf(h, "config_contents %s", b)
+	if v := data.SourceHash.ValueString(); v != "" {
+		fmt.Fprintf(h, "\nsource_hash %s", v)
+	}
+
+	var env, sensitive map[string]string
+	var byArch map[string]map[string]string
+	if diags := data.Env.ElementsAs(ctx, &env, false); diags.HasError() {
+		return "", fmt.Errorf("reading env: %v", diags.Errors())
+	}
+	if diags := data.EnvByArch.ElementsAs(ctx, &byArch, false); diags.HasError() {
+		return "", fmt.Errorf("reading env_by_arch: %v", diags.Errors())
+	}
+	if diags := data.SensitiveEnv.ElementsAs(ctx, &sensitive, false); diags.HasError() {
+		return "", fmt.Errorf("reading sensitive_env: %v", diags.Errors())
+	}
+	writeEnv := func(section string, m map[string]string) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			// JSON quoting keeps keys and values containing newlines or
+			// separators from colliding.
+			kb, _ := json.Marshal(k)
+			vb, _ := json.Marshal(m[k])
+			fmt.Fprintf(h, "\n%s %s=%s", section, kb, vb)
+		}
+	}
+	writeEnv("env", env)
+	archs := make([]string, 0, len(byArch))
+	for a := range byArch {
+		archs = append(archs, a)
+	}
+	sort.Strings(archs)
+	for _, a := range archs {
+		writeEnv("env_by_arch."+a, byArch[a])
+	}
+	// The names of the provider's variables, not their values.
+	writeEnv("sensitive_env", sensitive)
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// redactingWriter replaces secrets in everything written to it.
+type redactingWriter struct {
+	io.Writer
+	r *strings.Replacer
+}
+
+func newRedactingWriter(w io.Writer, secrets []string) io.Writer {
+	if len(secrets) == 0 {
+		return w
+	}
+	// Replace longer secrets first, so one containing another is redacted
+	// entirely.
+	secrets = append([]string(nil), secrets...)
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	var pairs []string
+	for _, s := range secrets {
+		pairs = append(pairs, s, redacted)
+	}
+	return redactingWriter{Writer: w, r: strings.NewReplacer(pairs...)}
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.Writer, w.r.Replace(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactLogs redacts secrets from the build's logs. melange's runners log
+// the environment they start guests with, so this relies on the runner
+// sharing the build's logger, as it does when build.New creates both. apko
+// and go-apk log through copies of the build's logger made when the guest is
+// built, later, so they share its redacting writer too. build.New also sends
+// ggcr's warnings and progress to the log, but through ggcr's global loggers,
+// which are pointed at the redacting writer instead.
+func redactLogs(bc *build.Build, secrets []string) {
+	if a, ok := bc.Logger.(*apkolog.Adapter); ok {
+		a.Out = newRedactingWriter(a.Out, secrets)
+		logs.Warn.SetOutput(a.Out)
+		logs.Progress.SetOutput(a.Out)
+	}
+}
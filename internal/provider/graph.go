@@ -0,0 +1,216 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// graphProvider is a package built by one of the configs that a dependency
+// can be resolved to: the package or one of its subpackages, or a name one of
+// them provides.
+type graphProvider struct {
+	origin     string // the package whose config builds it
+	pkg        string // the package or subpackage
+	pkgVersion string // pkg's version, if it has one
+	version    string // its version, if it has one
+	named      bool   // whether it's a package of the name, rather than one providing it
+	priority   int64  // pkg's provider-priority
+}
+
+// resolution is how a dependency of a config's package was resolved to the
+// package another config builds.
+type resolution struct {
+	pkg, dependency, origin, version string
+}
+
+// graphProviders returns the providers among the configs of each name: the
+// configs' packages and subpackages, and what they provide. Versioned
+// streams, like openssl-3.1 and openssl-3.2, may both provide a name, but
+// configs must build packages and subpackages of different names.
+func graphProviders(configs []configModel) (map[string][]graphProvider, error) {
+	providers := map[string][]graphProvider{}
+	built := map[string]int{} // the index of the config building each package
+	for i, cfg := range configs {
+		origin := cfg.Package.Name.ValueString()
+		var version string
+		if v := cfg.Package.Version.ValueString(); v != "" {
+			version = fmt.Sprintf("%s-r%d", v, cfg.Package.Epoch.ValueInt64())
+		}
+		add := func(name string, priority types.Int64, provides []string) error {
+			if j, ok := built[name]; ok {
+				if j == i {
+					return fmt.Errorf("the config of %s builds %s more than once", origin, name)
+				}
+				return fmt.Errorf("%s is built by the configs of both %s and %s", name, configs[j].Package.Name.ValueString(), origin)
+			}
+			built[name] = i
+			p := graphProvider{origin: origin, pkg: name, pkgVersion: version, priority: priority.ValueInt64()}
+			named := p
+			named.version, named.named = version, true
+			providers[name] = append(providers[name], named)
+			for _, provided := range provides {
+				n, v, _ := strings.Cut(provided, "=")
+				p.version = v
+				providers[n] = append(providers[n], p)
+			}
+			return nil
+		}
+		if err := add(origin, cfg.Package.ProviderPriority, cfg.Package.Provides); err != nil {
+			return nil, err
+		}
+		for _, sp := range cfg.Subpackages {
+			if err := add(sp.Name.ValueString(), sp.ProviderPriority, sp.Provides); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return providers, nil
+}
+
+// graphDeps resolves the packages installed in each config's build
+// environment to the packages the configs build, returning the packages each
+// config's package depends on, keyed by its name, and how each dependency
+// was resolved. A dependency on a subpackage, or on a name a package
+// provides, is on the package whose config builds it. Dependencies no config
+// satisfies, which come from elsewhere, and those a config satisfies itself,
+// are left out.
+func graphDeps(configs []configModel, providers map[string][]graphProvider) (map[string][]string, []resolution) {
+	deps := make(map[string][]string, len(configs))
+	var resolutions []resolution
+	for _, cfg := range configs {
+		name := cfg.Package.Name.ValueString()
+		needs := sets.New[string]()
+		if contents := cfg.Environment.Contents; contents != nil {
+			for _, dep := range contents.Packages {
+				p, ok := resolveDependency(dep, providers)
+				if !ok || p.origin == name {
+					continue
+				}
+				needs.Insert(p.origin)
+				resolutions = append(resolutions, resolution{pkg: name, dependency: dep, origin: p.origin, version: p.version})
+			}
+		}
+		deps[name] = sets.List(needs)
+	}
+	return deps, resolutions
+}
+
+// resolveDependency returns the provider dep resolves to, the way apk would
+// choose it among those that satisfy its version constraint: the newest,
+// preferring packages of the name to those providing it, then the package
+// with the highest provider-priority, then the newest package. Ties left
+// after that, like two streams providing the same so: name, go to the package
+// whose name sorts first, so the graph doesn't change from one read to the
+// next. ok is false if there's none.
+func resolveDependency(dep string, providers map[string][]graphProvider) (graphProvider, bool) {
+	if strings.HasPrefix(dep, "!") {
+		// A conflict, not a dependency.
+		return graphProvider{}, false
+	}
+	name, op, constraint := parseDependency(dep)
+	var candidates []graphProvider
+	for _, p := range providers[name] {
+		if satisfiesConstraint(p.version, op, constraint) {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return graphProvider{}, false
+	}
+	sort.Slice(candidates, func(i, j int) bool { return preferProvider(candidates[i], candidates[j]) })
+	return candidates[0], true
+}
+
+// preferProvider reports whether a is chosen over b; see resolveDependency.
+func preferProvider(a, b graphProvider) bool {
+	if c := compareProvidedVersions(a.version, b.version); c != 0 {
+		return c > 0
+	}
+	if a.named != b.named {
+		return a.named
+	}
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	if c := compareProvidedVersions(a.pkgVersion, b.pkgVersion); c != 0 {
+		return c > 0
+	}
+	return a.pkg < b.pkg
+}
+
+// compareProvidedVersions compares versions like compareAPKVersions, with
+// names provided without a version older than any with one.
+func compareProvidedVersions(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return -1
+	case b == "":
+		return 1
+	}
+	return compareAPKVersions(a, b)
+}
+
+// parseDependency splits a dependency like openssl>3.2 or busybox@local into
+// the name of the package it depends on, and its version constraint's
+// operator and version, if it has one. Repository tags are dropped.
+func parseDependency(dep string) (name, op, version string) {
+	i := strings.IndexAny(dep, "<>=~@")
+	if i < 0 {
+		return dep, "", ""
+	}
+	name, rest := dep[:i], dep[i:]
+	if strings.HasPrefix(rest, "@") {
+		j := strings.IndexAny(rest, "<>=~")
+		if j < 0 {
+			return name, "", ""
+		}
+		rest = rest[j:]
+	}
+	version = strings.TrimLeft(rest, "<>=~")
+	return name, rest[:len(rest)-len(version)], version
+}
+
+// satisfiesConstraint returns whether the version of a provider satisfies
+// the constraint op constraint. Constraints without an epoch, like =1.2.3,
+// ignore the provider's, and ~ matches versions the constraint is a prefix
+// of, like ~3.2 does 3.2.1. Only dependencies without a constraint are
+// satisfied by names provided without a version.
+func satisfiesConstraint(version, op, constraint string) bool {
+	if op == "" {
+		return true
+	}
+	if version == "" {
+		return false
+	}
+	if !strings.Contains(constraint, "-r") {
+		if i := strings.LastIndex(version, "-r"); i >= 0 {
+			version = version[:i]
+		}
+	}
+	if op == "~" || op == "~=" {
+		return version == constraint || (strings.HasPrefix(version, constraint) && strings.ContainsAny(version[len(constraint):len(constraint)+1], ".-_"))
+	}
+	c := compareAPKVersions(version, constraint)
+	switch op {
+	case "=", "==":
+		return c == 0
+	case "<":
+		return c < 0
+	case "<=", "=<":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=", "=>":
+		return c >= 0
+	}
+	return false
+}
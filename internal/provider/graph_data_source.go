@@ -9,10 +9,14 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/hashicorp/terraform-plugin-framework/attr"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 // Ensure provider defined types fully satisfy framework interfaces.
@@ -29,9 +33,20 @@ type GraphDataSource struct {
 
 // GraphDataSourceModel describes the data source data model.
 type GraphDataSourceModel struct {
-	Configs []types.Object `tfsdk:"configs"`
-	Deps    types.Map      `tfsdk:"deps"`
-	Id      types.String   `tfsdk:"id"`
+	Configs     []types.Object `tfsdk:"configs"`
+	Deps        types.Map      `tfsdk:"deps"`
+	Origins     types.Map      `tfsdk:"origins"`
+	Resolutions types.List     `tfsdk:"resolutions"`
+	Id          types.String   `tfsdk:"id"`
+}
+
+var resolutionType = basetypes.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"package":    basetypes.StringType{},
+		"dependency": basetypes.StringType{},
+		"origin":     basetypes.StringType{},
+		"version":    basetypes.StringType{},
+	},
 }
 
 func (d *GraphDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
@@ -44,20 +59,32 @@ func (d *GraphDataSource) Schema(ctx context.Context, req datasource.SchemaReque
 		MarkdownDescription: "Graph data source",
 
 		Attributes: map[string]schema.Attribute{
-			"configs": schema.ListAttribute{
+			"configs": schema.ListNestedAttribute{
 				MarkdownDescription: "List of configs",
 				Required:            true,
-				ElementType: basetypes.ObjectType{
-					AttrTypes: configSchema.AttrTypes,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: dataSourceConfigAttributes(configAttributes, false),
 				},
 			},
 			"deps": schema.MapAttribute{
-				MarkdownDescription: "Map of dependencies: this -> [needs]",
+				MarkdownDescription: "Map of dependencies: this -> [needs]. Each config's package needs the packages built by the other configs that are installed in its build environment. A dependency on a subpackage, or on a name a package provides, is on the package whose config builds it.",
+				Computed:            true,
+				ElementType: basetypes.ListType{
+					ElemType: basetypes.StringType{},
+				},
+			},
+			"origins": schema.MapAttribute{
+				MarkdownDescription: "The packages whose configs build or provide each name: the packages and their subpackages, and the names they provide. Versioned streams of a package, like `openssl-3.1` and `openssl-3.2`, may both provide `openssl`.",
 				Computed:            true,
 				ElementType: basetypes.ListType{
 					ElemType: basetypes.StringType{},
 				},
 			},
+			"resolutions": schema.ListAttribute{
+				MarkdownDescription: "How each dependency in `deps` was resolved: the `package` that depends on it, the `dependency` as written in its build environment, and the `origin` and `version` of the package chosen to satisfy it. Like apk, the newest version that satisfies a constraint like `openssl>3.2` is chosen, preferring packages of the name to those providing it, then the highest `provider_priority`, then the newest package. Remaining ties, like two streams providing the same `so:` name, go to the package whose name sorts first.",
+				Computed:            true,
+				ElementType:         resolutionType,
+			},
 			"id": schema.StringAttribute{
 				MarkdownDescription: "Graph identifier",
 				Computed:            true,
@@ -96,6 +123,49 @@ func (d *GraphDataSource) Read(ctx context.Context, req datasource.ReadRequest,
 	}
 	data.Id = types.StringValue(fmt.Sprintf("%x", sha256.Sum256(b)))
 
+	configs := make([]configModel, 0, len(data.Configs))
+	for i, c := range data.Configs {
+		cfg, err := parseConfigValue(ctx, c)
+		if err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("configs").AtListIndex(i), "Invalid config", err.Error())
+			return
+		}
+		configs = append(configs, cfg)
+	}
+	providers, err := graphProviders(configs)
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("configs"), "Invalid configs", err.Error())
+		return
+	}
+	deps, resolutions := graphDeps(configs, providers)
+	origins := make(map[string][]string, len(providers))
+	for name, ps := range providers {
+		set := sets.New[string]()
+		for _, p := range ps {
+			set.Insert(p.origin)
+		}
+		origins[name] = sets.List(set)
+	}
+	var diags diag.Diagnostics
+	data.Origins, diags = types.MapValueFrom(ctx, types.ListType{ElemType: types.StringType}, origins)
+	resp.Diagnostics.Append(diags...)
+	data.Deps, diags = types.MapValueFrom(ctx, types.ListType{ElemType: types.StringType}, deps)
+	resp.Diagnostics.Append(diags...)
+	vals := make([]attr.Value, 0, len(resolutions))
+	for _, r := range resolutions {
+		vals = append(vals, basetypes.NewObjectValueMust(resolutionType.AttrTypes, map[string]attr.Value{
+			"package":    types.StringValue(r.pkg),
+			"dependency": types.StringValue(r.dependency),
+			"origin":     types.StringValue(r.origin),
+			"version":    types.StringValue(r.version),
+		}))
+	}
+	data.Resolutions, diags = types.ListValue(resolutionType, vals)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	// Save data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
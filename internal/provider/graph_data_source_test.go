@@ -4,6 +4,7 @@
 package provider
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
@@ -25,3 +26,110 @@ data "melange_graph" "graph" {
 		},
 	})
 }
+
+func TestAccGraphDataSource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "split" {
+	config_contents = file("${path.module}/testdata/subpackages.yaml")
+}
+
+data "melange_config" "reader" {
+	config_contents = <<EOF
+package:
+  name: reader
+  version: 0.0.1
+  epoch: 0
+environment:
+  contents:
+    packages:
+      - busybox
+      - split-doc>=0.0.1
+pipeline:
+  - runs: cat /usr/share/doc/split/README
+EOF
+}
+
+data "melange_graph" "graph" {
+	configs = [
+		data.melange_config.split.config,
+		data.melange_config.reader.config,
+	]
+}
+`,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "origins.%", "3"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "origins.split-doc.#", "1"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "origins.split-doc.0", "split"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "deps.%", "2"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "deps.split.#", "0"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "deps.reader.#", "1"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "deps.reader.0", "split"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "resolutions.#", "1"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "resolutions.0.package", "reader"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "resolutions.0.dependency", "split-doc>=0.0.1"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "resolutions.0.origin", "split"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "resolutions.0.version", "0.0.1-r0"),
+			),
+		}},
+	})
+}
+
+func TestAccGraphDataSource_Streams(t *testing.T) {
+	stream := func(version string) string {
+		return fmt.Sprintf(`
+data "melange_config" "stream-%[1]s" {
+	config_contents = <<EOF
+package:
+  name: stream-%[1]s
+  version: %[1]s.0
+  epoch: 0
+  dependencies:
+    provides:
+      - stream=%[1]s.0-r0
+pipeline:
+  - runs: echo %[1]s
+EOF
+}
+`, version)
+	}
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{{
+			Config: stream("1") + stream("2") + `
+data "melange_config" "consumer" {
+	config_contents = <<EOF
+package:
+  name: consumer
+  version: 0.0.1
+  epoch: 0
+environment:
+  contents:
+    packages:
+      - stream<2
+pipeline:
+  - runs: echo consumer
+EOF
+}
+
+data "melange_graph" "graph" {
+	configs = [
+		data.melange_config.stream-1.config,
+		data.melange_config.stream-2.config,
+		data.melange_config.consumer.config,
+	]
+}
+`,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.melange_config.stream-2", "config.package.provides.0", "stream=2.0-r0"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "origins.stream.#", "2"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "deps.consumer.#", "1"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "deps.consumer.0", "stream-1"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "resolutions.0.origin", "stream-1"),
+				resource.TestCheckResourceAttr("data.melange_graph.graph", "resolutions.0.version", "1.0-r0"),
+			),
+		}},
+	})
+}
@@ -0,0 +1,195 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// graphTestConfig returns the config of a package named name at version,
+// with subpackages, whose build installs packages.
+func graphTestConfig(name, version string, subpackages []string, packages ...string) configModel {
+	cfg := configModel{
+		Package:     &packageModel{Name: types.StringValue(name), Version: types.StringValue(version), Epoch: types.Int64Value(0)},
+		Environment: &environmentModel{Contents: &contentsModel{Packages: packages}},
+	}
+	for _, sp := range subpackages {
+		cfg.Subpackages = append(cfg.Subpackages, subpackageModel{Name: types.StringValue(sp)})
+	}
+	return cfg
+}
+
+func TestGraphDeps(t *testing.T) {
+	configs := []configModel{
+		graphTestConfig("zlib", "1.3", []string{"zlib-dev", "zlib-doc"}, "busybox", "build-base"),
+		graphTestConfig("openssl", "3.2.0", []string{"openssl-dev", "libcrypto3"}, "busybox", "zlib-dev", "perl"),
+		graphTestConfig("curl", "8.4.0", nil, "openssl-dev>3", "libcrypto3", "zlib-dev@local", "zlib", "!curl-rustls"),
+		// Packages that install their own subpackages don't depend on themselves.
+		graphTestConfig("bootstrap", "1", []string{"bootstrap-stage0"}, "bootstrap-stage0"),
+	}
+	providers, err := graphProviders(configs)
+	if err != nil {
+		t.Fatalf("graphProviders: %v", err)
+	}
+	deps, resolutions := graphDeps(configs, providers)
+	if want := map[string][]string{
+		"zlib":      {},
+		"openssl":   {"zlib"},
+		"curl":      {"openssl", "zlib"},
+		"bootstrap": {},
+	}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("deps = %v, want %v", deps, want)
+	}
+	if want := []resolution{
+		{pkg: "openssl", dependency: "zlib-dev", origin: "zlib", version: "1.3-r0"},
+		{pkg: "curl", dependency: "openssl-dev>3", origin: "openssl", version: "3.2.0-r0"},
+		{pkg: "curl", dependency: "libcrypto3", origin: "openssl", version: "3.2.0-r0"},
+		{pkg: "curl", dependency: "zlib-dev@local", origin: "zlib", version: "1.3-r0"},
+		{pkg: "curl", dependency: "zlib", origin: "zlib", version: "1.3-r0"},
+	}; !reflect.DeepEqual(resolutions, want) {
+		t.Errorf("resolutions = %v, want %v", resolutions, want)
+	}
+}
+
+func TestGraphDeps_Streams(t *testing.T) {
+	openssl31 := graphTestConfig("openssl-3.1", "3.1.4", []string{"libcrypto3-3.1"})
+	openssl31.Package.Provides = []string{"openssl=3.1.4-r0"}
+	openssl31.Subpackages[0].Provides = []string{"libcrypto3=3.1.4-r0", "so:libcrypto.so.3=3"}
+	openssl32 := graphTestConfig("openssl-3.2", "3.2.1", []string{"libcrypto3-3.2"})
+	openssl32.Package.Provides = []string{"openssl=3.2.1-r0"}
+	openssl32.Subpackages[0].Provides = []string{"libcrypto3=3.2.1-r0", "so:libcrypto.so.3=3"}
+	configs := []configModel{
+		openssl31,
+		openssl32,
+		graphTestConfig("new", "1", nil, "openssl>3.2"),
+		graphTestConfig("old", "1", nil, "openssl<3.2"),
+		graphTestConfig("pinned", "1", nil, "openssl=3.1.4"),
+		graphTestConfig("fuzzy", "1", nil, "libcrypto3~3.1"),
+		graphTestConfig("latest", "1", nil, "openssl"),
+		graphTestConfig("future", "1", nil, "openssl>=4"),
+		// Both streams provide the same version of so:libcrypto.so.3, so
+		// the newer package is chosen.
+		graphTestConfig("linked", "1", nil, "so:libcrypto.so.3"),
+	}
+	providers, err := graphProviders(configs)
+	if err != nil {
+		t.Fatalf("graphProviders: %v", err)
+	}
+	deps, resolutions := graphDeps(configs, providers)
+	for name, want := range map[string][]string{
+		"new":    {"openssl-3.2"},
+		"old":    {"openssl-3.1"},
+		"pinned": {"openssl-3.1"},
+		"fuzzy":  {"openssl-3.1"},
+		"latest": {"openssl-3.2"},
+		"linked": {"openssl-3.2"},
+		// Versions no config builds come from elsewhere.
+		"future": {},
+	} {
+		if got := deps[name]; !reflect.DeepEqual(got, want) {
+			t.Errorf("deps[%s] = %v, want %v", name, got, want)
+		}
+	}
+	if got, want := resolutions[0], (resolution{pkg: "new", dependency: "openssl>3.2", origin: "openssl-3.2", version: "3.2.1-r0"}); got != want {
+		t.Errorf("resolutions[0] = %+v, want %+v", got, want)
+	}
+
+	// provider-priority outranks the newer package.
+	openssl31.Subpackages[0].ProviderPriority = types.Int64Value(10)
+	providers, err = graphProviders(configs)
+	if err != nil {
+		t.Fatalf("graphProviders: %v", err)
+	}
+	if deps, _ := graphDeps(configs, providers); !reflect.DeepEqual(deps["linked"], []string{"openssl-3.1"}) {
+		t.Errorf("deps[linked] = %v, want the stream with the higher provider-priority", deps["linked"])
+	}
+}
+
+func TestResolveDependency_Unversioned(t *testing.T) {
+	// Names provided without a version, like cmd:, are a tie broken by the
+	// package's version, then its name.
+	foo := graphTestConfig("foo", "1.0", nil)
+	foo.Package.Provides = []string{"cmd:tool"}
+	bar := graphTestConfig("bar", "1.0", nil)
+	bar.Package.Provides = []string{"cmd:tool"}
+	baz := graphTestConfig("baz", "0.9", nil)
+	baz.Package.Provides = []string{"cmd:tool"}
+	providers, err := graphProviders([]configModel{foo, bar, baz})
+	if err != nil {
+		t.Fatalf("graphProviders: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if p, ok := resolveDependency("cmd:tool", providers); !ok || p.origin != "bar" {
+			t.Fatalf("resolveDependency(cmd:tool) = %+v, %t, want bar", p, ok)
+		}
+	}
+}
+
+func TestGraphProviders_Conflict(t *testing.T) {
+	for desc, configs := range map[string][]configModel{
+		"package": {
+			graphTestConfig("foo", "1", nil),
+			graphTestConfig("foo", "2", nil),
+		},
+		"subpackage": {
+			graphTestConfig("foo", "1", []string{"foo-dev"}),
+			graphTestConfig("bar", "1", []string{"foo-dev"}),
+		},
+		"subpackage named after another package": {
+			graphTestConfig("foo", "1", nil),
+			graphTestConfig("bar", "1", []string{"foo"}),
+		},
+	} {
+		if _, err := graphProviders(configs); err == nil {
+			t.Errorf("%s: graphProviders: want an error for a package built by two configs", desc)
+		}
+	}
+}
+
+func TestSatisfiesConstraint(t *testing.T) {
+	for _, c := range []struct {
+		version, op, constraint string
+		want                    bool
+	}{
+		{"3.2.1-r0", "", "", true},
+		{"", "", "", true},
+		{"", ">", "1", false},
+		{"3.2.1-r0", ">", "3.2", true},
+		{"3.1.4-r0", ">", "3.2", false},
+		{"3.1.4-r0", "<", "3.2", true},
+		{"3.2.1-r0", ">=", "3.2.1", true},
+		{"3.2.1-r2", "=", "3.2.1", true},
+		{"3.2.1-r2", "=", "3.2.1-r1", false},
+		{"3.2.1-r2", ">", "3.2.1-r1", true},
+		{"3.2.1-r0", "~", "3.2", true},
+		{"3.21.0-r0", "~", "3.2", false},
+		{"3.2.1-r0", "<=", "3.2.0", false},
+	} {
+		if got := satisfiesConstraint(c.version, c.op, c.constraint); got != c.want {
+			t.Errorf("satisfiesConstraint(%q, %q, %q) = %t, want %t", c.version, c.op, c.constraint, got, c.want)
+		}
+	}
+}
+
+func TestParseDependency(t *testing.T) {
+	for _, c := range []struct {
+		dep, name, op, version string
+	}{
+		{"busybox", "busybox", "", ""},
+		{"openssl>3.2", "openssl", ">", "3.2"},
+		{"openssl>=3.2.1-r0", "openssl", ">=", "3.2.1-r0"},
+		{"libcrypto3~3.1", "libcrypto3", "~", "3.1"},
+		{"zlib@local", "zlib", "", ""},
+		{"zlib@local=1.3", "zlib", "=", "1.3"},
+		{"so:libc.so.6", "so:libc.so.6", "", ""},
+	} {
+		name, op, version := parseDependency(c.dep)
+		if name != c.name || op != c.op || version != c.version {
+			t.Errorf("parseDependency(%q) = %q, %q, %q, want %q, %q, %q", c.dep, name, op, version, c.name, c.op, c.version)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"gopkg.in/yaml.v3"
+)
+
+// lintFinding is a problem reported by a lint rule.
+type lintFinding struct {
+	Rule string
+	configError
+}
+
+// reportFunc records a problem at the given key path and YAML node.
+type reportFunc func(path string, n *yaml.Node, format string, args ...any)
+
+// lintRule checks a parsed melange configuration document, calling report
+// for each problem found.
+type lintRule func(doc *yaml.Node, report reportFunc)
+
+// lintRules are the lint rules run over melange configurations, by name.
+// All rules are enabled unless disabled with `lint_rules`.
+var lintRules = map[string]lintRule{
+	"copyright": func(doc *yaml.Node, report reportFunc) {
+		pkg := lookup(doc, "package")
+		if len(sequence(lookup(pkg, "copyright"))) == 0 {
+			report("package.copyright", pkg, "package has no copyright")
+		}
+	},
+	"description": func(doc *yaml.Node, report reportFunc) {
+		pkg := lookup(doc, "package")
+		if d := lookup(pkg, "description"); d == nil || d.Value == "" {
+			report("package.description", pkg, "package has no description")
+		}
+	},
+	"fetch-checksum": func(doc *yaml.Node, report reportFunc) {
+		walkPipelines(doc, func(path string, p *yaml.Node) {
+			if uses := lookup(p, "uses"); uses == nil || uses.Value != "fetch" {
+				return
+			}
+			with := lookup(p, "with")
+			if lookup(with, "expected-sha256") == nil && lookup(with, "expected-sha512") == nil {
+				report(path, p, "fetch has no expected-sha256 or expected-sha512")
+			}
+		})
+	},
+	"git-checkout-commit": func(doc *yaml.Node, report reportFunc) {
+		walkPipelines(doc, func(path string, p *yaml.Node) {
+			if uses := lookup(p, "uses"); uses == nil || uses.Value != "git-checkout" {
+				return
+			}
+			if lookup(lookup(p, "with"), "expected-commit") == nil {
+				report(path, p, "git-checkout has no expected-commit")
+			}
+		})
+	},
+	"hardcoded-version": func(doc *yaml.Node, report reportFunc) {
+		version := lookup(lookup(doc, "package"), "version")
+		if version == nil || version.Value == "" {
+			return
+		}
+		walkPipelines(doc, func(path string, p *yaml.Node) {
+			uses := lookup(p, "uses")
+			if uses == nil || (uses.Value != "fetch" && uses.Value != "git-checkout") {
+				return
+			}
+			for _, key := range []string{"uri", "repository", "tag", "branch"} {
+				if v := lookup(lookup(p, "with"), key); v != nil && containsVersion(v.Value, version.Value) {
+					report(path+".with."+key, v, "%q contains the package version; use ${{package.version}} instead", v.Value)
+				}
+			}
+		})
+	},
+}
+
+// containsVersion reports whether s contains version as a whole token: not
+// as part of a longer version, like 1.2 in 1.23, 1.2.3 or 10.1.2.3. It may
+// be prefixed by letters, like v1.2.
+func containsVersion(s, version string) bool {
+	re := regexp.MustCompile(`(?:^|[^0-9.])` + regexp.QuoteMeta(version) + `(?:$|[^0-9A-Za-z.]|\.$|\.[^0-9])`)
+	return re.MatchString(s)
+}
+
+// lintConfig runs all enabled lint rules over the melange configuration.
+// Rules are enabled by default, and can be toggled by name in enabled.
+func lintConfig(contents []byte, enabled map[string]bool) []lintFinding {
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil || len(root.Content) == 0 {
+		// Parse errors are reported by validateConfig.
+		return nil
+	}
+	doc := root.Content[0]
+
+	names := make([]string, 0, len(lintRules))
+	for name := range lintRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var findings []lintFinding
+	for _, name := range names {
+		if on, ok := enabled[name]; ok && !on {
+			continue
+		}
+		name := name
+		lintRules[name](doc, func(path string, n *yaml.Node, format string, args ...any) {
+			f := lintFinding{Rule: name, configError: configError{Path: path, Message: fmt.Sprintf(format, args...)}}
+			if n != nil {
+				f.Line, f.Column = n.Line, n.Column
+			}
+			findings = append(findings, f)
+		})
+	}
+	return findings
+}
+
+var lintFindingType = basetypes.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"rule":    basetypes.StringType{},
+		"path":    basetypes.StringType{},
+		"line":    basetypes.Int64Type{},
+		"message": basetypes.StringType{},
+	},
+}
+
+func (f lintFinding) value() basetypes.ObjectValue {
+	return basetypes.NewObjectValueMust(lintFindingType.AttrTypes, map[string]attr.Value{
+		"rule":    basetypes.NewStringValue(f.Rule),
+		"path":    basetypes.NewStringValue(f.Path),
+		"line":    basetypes.NewInt64Value(int64(f.Line)),
+		"message": basetypes.NewStringValue(f.Message),
+	})
+}
@@ -0,0 +1,80 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"testing"
+)
+
+func TestLintConfig(t *testing.T) {
+	config := `
+package:
+  name: hello
+  version: 2.12
+  epoch: 0
+pipeline:
+  - uses: fetch
+    with:
+      uri: https://ftp.gnu.org/gnu/hello/hello-2.12.tar.gz
+  - uses: git-checkout
+    with:
+      repository: https://github.com/example/hello
+      tag: v${{package.version}}
+`
+	for _, c := range []struct {
+		desc    string
+		enabled map[string]bool
+		want    []string
+	}{{
+		desc: "all rules",
+		want: []string{
+			"copyright: package.copyright, line 3, column 3: package has no copyright",
+			"description: package.description, line 3, column 3: package has no description",
+			"fetch-checksum: pipeline[0], line 7, column 5: fetch has no expected-sha256 or expected-sha512",
+			"git-checkout-commit: pipeline[1], line 10, column 5: git-checkout has no expected-commit",
+			`hardcoded-version: pipeline[0].with.uri, line 9, column 12: "https://ftp.gnu.org/gnu/hello/hello-2.12.tar.gz" contains the package version; use ${{package.version}} instead`,
+		},
+	}, {
+		desc:    "disabled rules",
+		enabled: map[string]bool{"copyright": false, "description": false, "fetch-checksum": false, "hardcoded-version": true},
+		want: []string{
+			"git-checkout-commit: pipeline[1], line 10, column 5: git-checkout has no expected-commit",
+			`hardcoded-version: pipeline[0].with.uri, line 9, column 12: "https://ftp.gnu.org/gnu/hello/hello-2.12.tar.gz" contains the package version; use ${{package.version}} instead`,
+		},
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			findings := lintConfig([]byte(config), c.enabled)
+			if len(findings) != len(c.want) {
+				t.Fatalf("got %d findings, want %d: %v", len(findings), len(c.want), findings)
+			}
+			for i, f := range findings {
+				if got := f.Rule + ": " + f.Error(); got != c.want[i] {
+					t.Errorf("finding %d: got %q, want %q", i, got, c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestContainsVersion(t *testing.T) {
+	for _, c := range []struct {
+		s, version string
+		want       bool
+	}{
+		{"https://ftp.gnu.org/gnu/hello/hello-2.12.tar.gz", "2.12", true},
+		{"v1.2", "1.2", true},
+		{"release-1.2", "1.2", true},
+		{"1.2.", "1.2", true},
+		{"https://example.com/1.2/src.tar.gz", "1.2", true},
+		{"https://go.dev/dl/go1.23.tar.gz", "1.2", false},
+		{"v1.2.3", "1.2", false},
+		{"https://10.1.2.3/src.tar.gz", "1.2", false},
+		{"1.2rc1", "1.2", false},
+		{"https://example.com/${{package.version}}.tar.gz", "1.2", false},
+	} {
+		if got := containsVersion(c.s, c.version); got != c.want {
+			t.Errorf("containsVersion(%q, %q) = %t, want %t", c.s, c.version, got, c.want)
+		}
+	}
+}
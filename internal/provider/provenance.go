@@ -0,0 +1,401 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	inTotoPayloadType   = "application/vnd.in-toto+json"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v1"
+	provenanceBuildType = "https://github.com/imjasonh/terraform-provider-melange/build@v1"
+	provenanceBuilderID = "https://github.com/imjasonh/terraform-provider-melange"
+
+	// provenanceSourceDir names the resolved dependency recording the
+	// digest of the build's source directory.
+	provenanceSourceDir = "source_dir"
+
+	// provenanceFingerprint names the internal parameter recording the
+	// build's fingerprint.
+	provenanceFingerprint = "fingerprint"
+)
+
+// inTotoStatement is an in-toto v1 statement with a SLSA v1 provenance
+// predicate.
+type inTotoStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []resourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     slsaProvenance       `json:"predicate"`
+}
+
+type resourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition struct {
+		BuildType            string               `json:"buildType"`
+		ExternalParameters   map[string]any       `json:"externalParameters"`
+		InternalParameters   map[string]any       `json:"internalParameters,omitempty"`
+		ResolvedDependencies []resourceDescriptor `json:"resolvedDependencies,omitempty"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			StartedOn  string `json:"startedOn,omitempty"`
+			FinishedOn string `json:"finishedOn,omitempty"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// dsseEnvelope is a DSSE envelope wrapping a signed in-toto statement.
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// provenanceSigner signs provenance statements.
+type provenanceSigner interface {
+	// Sign returns the signature of data.
+	Sign(data []byte) ([]byte, error)
+	// KeyID identifies the key that signs.
+	KeyID() string
+}
+
+// rsaSigner signs with the RSA private key in keyFile, the key melange signs
+// APKs with. APK signatures are over SHA-1 digests, which in-toto and cosign
+// don't verify, so this signs PKCS #1 v1.5 over SHA-256 digests instead.
+type rsaSigner struct {
+	keyFile string
+}
+
+func (s rsaSigner) Sign(data []byte) ([]byte, error) {
+	b, err := os.ReadFile(s.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s isn't PEM encoded", s.keyFile)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, perr := x509.ParsePKCS8PrivateKey(block.Bytes)
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if perr != nil || !ok {
+			return nil, fmt.Errorf("signing key %s isn't an RSA private key: %w", s.keyFile, err)
+		}
+		key = rsaKey
+	}
+	digest := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+}
+
+// KeyID is the name of the public key, which melange writes next to the
+// private key.
+func (s rsaSigner) KeyID() string {
+	return filepath.Base(s.keyFile) + ".pub"
+}
+
+// buildProvenance is what's known about one arch's build, shared by the
+// statements for each APK it produced.
+type buildProvenance struct {
+	arch              apkotypes.Architecture
+	config            []byte
+	sources           []cacheEntry
+	sourceDir         string
+	sourceHash        string // see hashSourceDir
+	fingerprint       string // see fingerprint
+	builderImage      string
+	installed         []string // name=version
+	runner, namespace string
+	repositories      []string
+	keyring           []string
+	sourceDateEpoch   time.Time
+	started, finished time.Time
+}
+
+// statement returns the provenance statement for the APK at apkPath.
+func (p buildProvenance) statement(apkPath string) (inTotoStatement, error) {
+	digest, err := fileDigest(apkPath)
+	if err != nil {
+		return inTotoStatement{}, err
+	}
+
+	st := inTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       []resourceDescriptor{{Name: filepath.Base(apkPath), Digest: map[string]string{"sha256": digest}}},
+		PredicateType: slsaProvenanceType,
+	}
+	bd := &st.Predicate.BuildDefinition
+	bd.BuildType = provenanceBuildType
+	bd.ExternalParameters = map[string]any{
+		"config": resourceDescriptor{Digest: map[string]string{"sha256": fmt.Sprintf("%x", sha256.Sum256(p.config))}},
+		"arch":   p.arch.ToAPK(),
+	}
+	bd.InternalParameters = map[string]any{
+		"runner":          p.runner,
+		"repositories":    nonNil(p.repositories),
+		"keyring":         nonNil(p.keyring),
+		"sourceDateEpoch": p.sourceDateEpoch.UTC().Format(time.RFC3339),
+	}
+	if p.namespace != "" {
+		bd.InternalParameters["namespace"] = p.namespace
+	}
+	if p.fingerprint != "" {
+		bd.InternalParameters[provenanceFingerprint] = p.fingerprint
+	}
+
+	for _, e := range p.sources {
+		d := resourceDescriptor{URI: e.URI}
+		if alg, hex, ok := strings.Cut(e.Key, ":"); ok {
+			if alg == "git" {
+				alg = "gitCommit"
+			}
+			d.Digest = map[string]string{alg: hex}
+		}
+		if e.Kind == "git-checkout" && e.Ref != "" {
+			d.Name = e.Ref
+		}
+		bd.ResolvedDependencies = append(bd.ResolvedDependencies, d)
+	}
+	if p.sourceDir != "" {
+		bd.ResolvedDependencies = append(bd.ResolvedDependencies, resourceDescriptor{
+			Name:   provenanceSourceDir,
+			URI:    "file://" + filepath.ToSlash(p.sourceDir),
+			Digest: map[string]string{"sha256": p.sourceHash},
+		})
+	}
+	if p.builderImage != "" {
+		d := resourceDescriptor{Name: "builder-image", URI: p.builderImage}
+		if _, hex, ok := strings.Cut(p.builderImage, "@sha256:"); ok {
+			d.Digest = map[string]string{"sha256": hex}
+		}
+		bd.ResolvedDependencies = append(bd.ResolvedDependencies, d)
+	}
+	installed := append([]string(nil), p.installed...)
+	sort.Strings(installed)
+	for _, pkg := range installed {
+		name, version, _ := strings.Cut(pkg, "=")
+		bd.ResolvedDependencies = append(bd.ResolvedDependencies, resourceDescriptor{
+			Name: name,
+			URI:  fmt.Sprintf("pkg:apk/%s@%s?arch=%s", name, version, p.arch.ToAPK()),
+		})
+	}
+
+	st.Predicate.RunDetails.Builder.ID = provenanceBuilderID
+	if !p.started.IsZero() {
+		st.Predicate.RunDetails.Metadata.StartedOn = p.started.UTC().Format(time.RFC3339)
+		st.Predicate.RunDetails.Metadata.FinishedOn = p.finished.UTC().Format(time.RFC3339)
+	}
+	return st, nil
+}
+
+// write writes the provenance statement for the APK at apkPath to an
+// .intoto.json file next to it. If signer is non-nil, the file holds a DSSE
+// envelope signed by it, otherwise the bare statement.
+func (p buildProvenance) write(apkPath string, signer provenanceSigner) error {
+	st, err := p.statement(apkPath)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if signer != nil {
+		sig, err := signer.Sign(dssePAE(inTotoPayloadType, b))
+		if err != nil {
+			return fmt.Errorf("signing provenance for %s: %w", filepath.Base(apkPath), err)
+		}
+		if b, err = json.MarshalIndent(dsseEnvelope{
+			PayloadType: inTotoPayloadType,
+			Payload:     base64.StdEncoding.EncodeToString(b),
+			Signatures:  []dsseSignature{{KeyID: signer.KeyID(), Sig: base64.StdEncoding.EncodeToString(sig)}},
+		}, "", "  "); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(provenancePath(apkPath), b, 0o644); err != nil {
+		return fmt.Errorf("writing provenance: %w", err)
+	}
+	return nil
+}
+
+// installedDB is where apk records the packages installed in a root
+// filesystem.
+const installedDB = "lib/apk/db/installed"
+
+// readInstalled returns the packages installed in the root filesystem at
+// dir, as name=version, from its apk database.
+func readInstalled(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, installedDB))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseInstalled(f)
+}
+
+// parseInstalled parses an apk database, which has a paragraph of `K:value`
+// lines for each package.
+func parseInstalled(r io.Reader) ([]string, error) {
+	var (
+		installed     []string
+		name, version string
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if name != "" {
+				installed = append(installed, name+"="+version)
+			}
+			name, version = "", ""
+		case strings.HasPrefix(line, "P:"):
+			name = line[2:]
+		case strings.HasPrefix(line, "V:"):
+			version = line[2:]
+		}
+	}
+	if name != "" {
+		installed = append(installed, name+"="+version)
+	}
+	return installed, sc.Err()
+}
+
+func provenancePath(apkPath string) string {
+	return strings.TrimSuffix(apkPath, ".apk") + ".intoto.json"
+}
+
+// dssePAE is the DSSE pre-authentication encoding of a payload, which is
+// what gets signed.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+var provenanceType = basetypes.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"arch":      basetypes.StringType{},
+		"package":   basetypes.StringType{},
+		"path":      basetypes.StringType{},
+		"signed":    basetypes.BoolType{},
+		"statement": basetypes.StringType{},
+	},
+}
+
+// readProvenance reads a provenance file written by write, returning the
+// statement and whether it was signed.
+func readProvenance(path string) ([]byte, bool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	var env dsseEnvelope
+	signed := json.Unmarshal(b, &env) == nil && env.PayloadType != ""
+	if signed {
+		if b, err = base64.StdEncoding.DecodeString(env.Payload); err != nil {
+			return nil, false, fmt.Errorf("decoding provenance %s: %w", path, err)
+		}
+	}
+	return b, signed, nil
+}
+
+// readStatement reads the provenance statement of the APK at apkPath, if it
+// has one.
+func readStatement(apkPath string) (inTotoStatement, bool) {
+	var st inTotoStatement
+	b, _, err := readProvenance(provenancePath(apkPath))
+	if err != nil {
+		return st, false
+	}
+	return st, json.Unmarshal(b, &st) == nil
+}
+
+// provenanceSourceHash returns the digest of the source directory the APK at
+// apkPath was built from, according to its provenance, if it has any.
+func provenanceSourceHash(apkPath string) string {
+	st, _ := readStatement(apkPath)
+	for _, d := range st.Predicate.BuildDefinition.ResolvedDependencies {
+		if d.Name == provenanceSourceDir {
+			return d.Digest["sha256"]
+		}
+	}
+	return ""
+}
+
+// provenanceBuildFingerprint returns the fingerprint of the build that produced
+// the APK at apkPath, according to its provenance, if it has any.
+func provenanceBuildFingerprint(apkPath string) string {
+	st, _ := readStatement(apkPath)
+	fp, _ := st.Predicate.BuildDefinition.InternalParameters[provenanceFingerprint].(string)
+	return fp
+}
+
+// upToDate reports whether the APK at apkPath was built with fingerprint fp.
+// APKs built before fingerprints were recorded are up to date unless their
+// source directory has changed.
+func upToDate(apkPath, fp, srcHash string) bool {
+	if recorded := provenanceBuildFingerprint(apkPath); recorded != "" {
+		return recorded == fp
+	}
+	return srcHash == "" || provenanceSourceHash(apkPath) == srcHash
+}
+
+// provenanceValue reads a provenance file written by write into the
+// attributes exposed by melange_build.
+func provenanceValue(arch, pkg, path string) (basetypes.ObjectValue, error) {
+	b, signed, err := readProvenance(path)
+	if err != nil {
+		return basetypes.ObjectValue{}, err
+	}
+	return basetypes.NewObjectValueMust(provenanceType.AttrTypes, map[string]attr.Value{
+		"arch":      basetypes.NewStringValue(arch),
+		"package":   basetypes.NewStringValue(pkg),
+		"path":      basetypes.NewStringValue(path),
+		"signed":    basetypes.NewBoolValue(signed),
+		"statement": basetypes.NewStringValue(string(b)),
+	}), nil
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,210 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+type fakeSigner struct{ signed []byte }
+
+func (s *fakeSigner) Sign(data []byte) ([]byte, error) {
+	s.signed = data
+	return []byte("signature"), nil
+}
+
+func (*fakeSigner) KeyID() string { return "local-melange.rsa.pub" }
+
+func TestProvenance(t *testing.T) {
+	dir := t.TempDir()
+	apk := filepath.Join(dir, "hello-1.0.0-r2.apk")
+	writeTestAPK(t, apk, []testFile{{name: "usr/bin/hello", contents: "hello", mode: 0o755}})
+	digest, err := fileDigest(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := buildProvenance{
+		arch:   apkotypes.ParseArchitecture("amd64"),
+		config: []byte("package:\n  name: hello\n"),
+		sources: []cacheEntry{
+			{Kind: "fetch", Key: "sha256:abc", URI: "https://example.com/hello.tar.gz"},
+			{Kind: "git-checkout", Key: "git:def", URI: "https://example.com/hello.git", Ref: "v1.0.0"},
+		},
+		sourceDir:       "/src/hello",
+		sourceHash:      "456",
+		fingerprint:     "789",
+		builderImage:    "registry.local/melange@sha256:123",
+		installed:       []string{"busybox=1.36.1-r0", "ca-certificates-bundle=20230506-r0"},
+		runner:          "docker",
+		repositories:    []string{"https://packages.wolfi.dev/os"},
+		sourceDateEpoch: time.Unix(0, 0),
+		started:         time.Unix(100, 0),
+		finished:        time.Unix(200, 0),
+	}
+	if err := p.write(apk, nil); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	v, err := provenanceValue("x86_64", "hello", provenancePath(apk))
+	if err != nil {
+		t.Fatalf("provenanceValue: %v", err)
+	}
+	if got := v.Attributes()["signed"].String(); got != "false" {
+		t.Errorf("got signed %s, want false", got)
+	}
+
+	var st inTotoStatement
+	if err := json.Unmarshal([]byte(v.Attributes()["statement"].(basetypes.StringValue).ValueString()), &st); err != nil {
+		t.Fatalf("statement isn't JSON: %v", err)
+	}
+	if st.Type != inTotoStatementType || st.PredicateType != slsaProvenanceType {
+		t.Errorf("got types %q, %q", st.Type, st.PredicateType)
+	}
+	if len(st.Subject) != 1 || st.Subject[0].Name != "hello-1.0.0-r2.apk" || st.Subject[0].Digest["sha256"] != digest {
+		t.Errorf("got subject %+v, want hello-1.0.0-r2.apk with sha256 %s", st.Subject, digest)
+	}
+	bd := st.Predicate.BuildDefinition
+	if bd.InternalParameters["runner"] != "docker" || bd.ExternalParameters["arch"] != "x86_64" {
+		t.Errorf("got parameters %v, %v", bd.ExternalParameters, bd.InternalParameters)
+	}
+	var deps []string
+	for _, d := range bd.ResolvedDependencies {
+		b, _ := json.Marshal(d)
+		deps = append(deps, string(b))
+	}
+	for i, want := range []string{
+		`{"uri":"https://example.com/hello.tar.gz","digest":{"sha256":"abc"}}`,
+		`{"name":"v1.0.0","uri":"https://example.com/hello.git","digest":{"gitCommit":"def"}}`,
+		`{"name":"source_dir","uri":"file:///src/hello","digest":{"sha256":"456"}}`,
+		`{"name":"builder-image","uri":"registry.local/melange@sha256:123","digest":{"sha256":"123"}}`,
+		`{"name":"busybox","uri":"pkg:apk/busybox@1.36.1-r0?arch=x86_64"}`,
+		`{"name":"ca-certificates-bundle","uri":"pkg:apk/ca-certificates-bundle@20230506-r0?arch=x86_64"}`,
+	} {
+		if i >= len(deps) || deps[i] != want {
+			t.Errorf("got dependencies:\n%s\nwant %s at %d", strings.Join(deps, "\n"), want, i)
+			break
+		}
+	}
+	if md := st.Predicate.RunDetails.Metadata; md.StartedOn != "1970-01-01T00:01:40Z" || md.FinishedOn != "1970-01-01T00:03:20Z" {
+		t.Errorf("got metadata %+v", md)
+	}
+
+	if got := provenanceSourceHash(apk); got != "456" {
+		t.Errorf("got source hash %q, want 456", got)
+	}
+	if !upToDate(apk, "789", "456") || upToDate(apk, "000", "456") {
+		t.Errorf("upToDate doesn't compare fingerprints")
+	}
+
+	signer := &fakeSigner{}
+	if err := p.write(apk, signer); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	b, err := os.ReadFile(provenancePath(apk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env dsseEnvelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		t.Fatalf("envelope isn't JSON: %v", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(signer.signed) != string(dssePAE(inTotoPayloadType, payload)) {
+		t.Errorf("signed %q, want the PAE of the payload", signer.signed)
+	}
+	if len(env.Signatures) != 1 || env.Signatures[0].KeyID != "local-melange.rsa.pub" {
+		t.Errorf("got signatures %+v", env.Signatures)
+	}
+	v, err = provenanceValue("x86_64", "hello", provenancePath(apk))
+	if err != nil {
+		t.Fatalf("provenanceValue: %v", err)
+	}
+	if got := v.Attributes()["signed"].String(); got != "true" {
+		t.Errorf("got signed %s, want true", got)
+	}
+	if got := v.Attributes()["statement"].(basetypes.StringValue).ValueString(); got != string(payload) {
+		t.Errorf("statement isn't the envelope's payload")
+	}
+	if got := provenanceSourceHash(apk); got != "456" {
+		t.Errorf("got source hash %q from signed provenance, want 456", got)
+	}
+	if got := provenanceBuildFingerprint(apk); got != "789" {
+		t.Errorf("got fingerprint %q from signed provenance, want 789", got)
+	}
+
+	// Packages built before fingerprints were recorded are rebuilt only if
+	// their sources changed.
+	p.fingerprint = ""
+	if err := p.write(apk, nil); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if !upToDate(apk, "789", "456") || upToDate(apk, "789", "000") {
+		t.Errorf("upToDate doesn't fall back to comparing source hashes")
+	}
+}
+
+func TestRSASigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "local-melange.rsa")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer := rsaSigner{keyFile: keyFile}
+	if got := signer.KeyID(); got != "local-melange.rsa.pub" {
+		t.Errorf("KeyID = %q", got)
+	}
+	data := dssePAE(inTotoPayloadType, []byte(`{"_type":"https://in-toto.io/Statement/v1"}`))
+	sig, err := signer.Sign(data)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("signature isn't PKCS #1 v1.5 over SHA-256: %v", err)
+	}
+}
+
+func TestParseInstalled(t *testing.T) {
+	db := `C:Q1abc=
+P:busybox
+V:1.36.1-r0
+A:x86_64
+F:bin
+R:busybox
+
+C:Q1def=
+P:ca-certificates-bundle
+V:20230506-r0
+A:x86_64
+`
+	got, err := parseInstalled(strings.NewReader(db))
+	if err != nil {
+		t.Fatalf("parseInstalled: %v", err)
+	}
+	if want := []string{"busybox=1.36.1-r0", "ca-certificates-bundle=20230506-r0"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("parseInstalled = %v, want %v", got, want)
+	}
+}
@@ -5,8 +5,12 @@ package provider
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 
+	"chainguard.dev/melange/pkg/container"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/provider"
 	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
@@ -32,11 +36,39 @@ type ProviderModel struct {
 	SigningKey        basetypes.StringValue `tfsdk:"signing_key"`
 	Runner            basetypes.StringValue `tfsdk:"runner"`
 	Namespace         basetypes.StringValue `tfsdk:"namespace"`
+	Offline           basetypes.BoolValue   `tfsdk:"offline"`
+	RetainVersions    basetypes.Int64Value  `tfsdk:"retain_versions"`
+	Docker            basetypes.ObjectValue `tfsdk:"docker"`
+	Bubblewrap        basetypes.ObjectValue `tfsdk:"bubblewrap"`
 }
 
 type ProviderOpts struct {
 	repositories, keyring, archs       []string
 	dir, signingKey, runner, namespace string
+	offline                            bool
+	mounts                             []container.BindMount
+	// retainVersions is how many versions of each package to keep in the
+	// local repository, or all of them if it's 0.
+	retainVersions int
+	// secrets are redacted from build logs.
+	secrets []string
+}
+
+// cacheDir returns the directory melange caches fetched sources in.
+func (o ProviderOpts) cacheDir() string {
+	return filepath.Join(o.dir, "melange-cache")
+}
+
+// pipelineDir returns the directory to load local pipelines from, preferring
+// a pipelines directory next to the config file if there is one.
+func (o ProviderOpts) pipelineDir(configDir string) string {
+	if configDir != "" {
+		dir := filepath.Join(configDir, "pipelines")
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+	}
+	return filepath.Join(o.dir, "pipelines")
 }
 
 func (p *Provider) Metadata(ctx context.Context, req provider.MetadataRequest, resp *provider.MetadataResponse) {
@@ -71,14 +103,23 @@ func (p *Provider) Schema(ctx context.Context, req provider.SchemaRequest, resp
 				Optional:    true,
 			},
 			"runner": schema.StringAttribute{
-				Description: "The runner to use for running the build",
+				Description: "The runner to use for running the build: one of `bubblewrap`, `docker`, `kubernetes` or `lima`. Defaults to `docker`, or the runner whose block is set.",
 				Optional:    true,
 			},
 			"namespace": schema.StringAttribute{
 				Description: "The namespace to use for the package",
 				Optional:    true,
 			},
+			"offline": schema.BoolAttribute{
+				Description: "Don't fetch sources from the network. Builds fail before starting if any source they fetch is missing from the cache, which can be populated with melange_source_cache. Builds of configurations with `git-checkout` steps fail to plan, since melange's `git-checkout` pipeline always clones from the network.",
+				Optional:    true,
+			},
+			"retain_versions": schema.Int64Attribute{
+				Description: "The number of versions of each package to keep in the local repository, counting each epoch as a version. When a build adds a package to the index, its older versions are removed from the index and their APKs, SBOMs, provenance and logs deleted. The version just built is always kept. Defaults to keeping all versions.",
+				Optional:    true,
+			},
 		},
+		Blocks: runnerBlocks,
 	}
 }
 
@@ -95,19 +136,28 @@ func (p *Provider) Configure(ctx context.Context, req provider.ConfigureRequest,
 	if data.SigningKey.ValueString() == "" {
 		data.SigningKey = basetypes.NewStringValue("local-melange.rsa")
 	}
-	if data.Runner.ValueString() == "" {
-		data.Runner = basetypes.NewStringValue("docker")
+	if data.RetainVersions.ValueInt64() < 0 {
+		resp.Diagnostics.AddAttributeError(path.Root("retain_versions"), "Invalid retain_versions", "retain_versions must not be negative.")
+		return
+	}
+	runner, mounts, diags := configureRunner(ctx, data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
 
 	opts := &ProviderOpts{
 		// This is only for testing, so we can inject provider config
-		repositories: append(p.repositories, data.ExtraRepositories...),
-		keyring:      append(p.keyring, data.ExtraKeyring...),
-		archs:        append(p.archs, data.DefaultArchs...),
-		dir:          data.Dir.ValueString(),
-		signingKey:   data.SigningKey.ValueString(),
-		runner:       data.Runner.ValueString(),
-		namespace:    data.Namespace.ValueString(),
+		repositories:   append(p.repositories, data.ExtraRepositories...),
+		keyring:        append(p.keyring, data.ExtraKeyring...),
+		archs:          append(p.archs, data.DefaultArchs...),
+		dir:            data.Dir.ValueString(),
+		signingKey:     data.SigningKey.ValueString(),
+		runner:         runner,
+		namespace:      data.Namespace.ValueString(),
+		offline:        data.Offline.ValueBool(),
+		retainVersions: int(data.RetainVersions.ValueInt64()),
+		mounts:         mounts,
 	}
 
 	// Make provider opts available to resources and data sources.
@@ -118,6 +168,8 @@ func (p *Provider) Configure(ctx context.Context, req provider.ConfigureRequest,
 func (p *Provider) Resources(ctx context.Context) []func() resource.Resource {
 	return []func() resource.Resource{
 		NewBuildResource,
+		NewSourceCacheResource,
+		NewTestResource,
 	}
 }
 
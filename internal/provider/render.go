@@ -0,0 +1,184 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing/fstest"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	melangeconfig "chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/util"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+var renderedStepType = basetypes.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"path": basetypes.StringType{},
+		"name": basetypes.StringType{},
+		"uses": basetypes.StringType{},
+		"with": basetypes.MapType{ElemType: basetypes.StringType{}},
+		"runs": basetypes.StringType{},
+	},
+}
+
+var renderedType = basetypes.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"vars":     basetypes.MapType{ElemType: basetypes.StringType{}},
+		"pipeline": basetypes.ListType{ElemType: renderedStepType},
+	},
+}
+
+// parseMelangeConfig parses the configuration the same way melange does at
+// build time, including subpackage ranges and package substitutions.
+func parseMelangeConfig(contents []byte) (*melangeconfig.Configuration, error) {
+	contents, _, err := splitTestSection(contents)
+	if err != nil {
+		return nil, err
+	}
+	const name = "melange.yaml"
+	return melangeconfig.ParseConfiguration(name, melangeconfig.WithFS(fstest.MapFS{
+		name: &fstest.MapFile{Data: contents},
+	}))
+}
+
+// substitutions returns the variables melange makes available to pipelines
+// when building for the given arch, keyed by their ${{...}} form. If subpkg is
+// set, the variables are those available to that subpackage's pipelines.
+//
+// Host triplets assume a glibc build environment, since the build guest isn't
+// available to inspect until build time.
+func substitutions(cfg *melangeconfig.Configuration, arch apkotypes.Architecture, subpkg string) (map[string]string, error) {
+	destdir := "/home/build/melange-out/" + cfg.Package.Name
+	nw := map[string]string{
+		melangeconfig.SubstitutionPackageName:          cfg.Package.Name,
+		melangeconfig.SubstitutionPackageVersion:       cfg.Package.Version,
+		melangeconfig.SubstitutionPackageEpoch:         strconv.FormatUint(cfg.Package.Epoch, 10),
+		melangeconfig.SubstitutionPackageFullVersion:   fmt.Sprintf("%s-r%d", cfg.Package.Version, cfg.Package.Epoch),
+		melangeconfig.SubstitutionPackageDescription:   cfg.Package.Description,
+		melangeconfig.SubstitutionTargetsDestdir:       destdir,
+		melangeconfig.SubstitutionTargetsContextdir:    destdir,
+		melangeconfig.SubstitutionHostTripletGnu:       arch.ToTriplet("gnu"),
+		melangeconfig.SubstitutionHostTripletRust:      arch.ToRustTriplet("gnu"),
+		melangeconfig.SubstitutionCrossTripletGnuGlibc: arch.ToTriplet("gnu"),
+		melangeconfig.SubstitutionCrossTripletGnuMusl:  arch.ToTriplet("musl"),
+		melangeconfig.SubstitutionBuildArch:            arch.ToAPK(),
+	}
+
+	vars, err := cfg.GetVarsFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range vars {
+		nw[k] = v
+	}
+	if err := cfg.PerformVarSubstitutions(nw); err != nil {
+		return nil, err
+	}
+
+	if subpkg != "" {
+		nw[melangeconfig.SubstitutionSubPkgDir] = "/home/build/melange-out/" + subpkg
+		nw[melangeconfig.SubstitutionTargetsContextdir] = nw[melangeconfig.SubstitutionSubPkgDir]
+	}
+	nw[fmt.Sprintf("${{targets.package.%s}}", cfg.Package.Name)] = destdir
+	for _, sp := range cfg.Subpackages {
+		nw[fmt.Sprintf("${{targets.package.%s}}", sp.Name)] = "/home/build/melange-out/" + sp.Name
+	}
+	return nw, nil
+}
+
+// renderConfig evaluates melange substitutions and var-transforms in the
+// configuration's pipelines for each arch, returning a map of arch to the
+// rendered variables and pipeline steps.
+func renderConfig(contents []byte, archs []apkotypes.Architecture) (basetypes.MapValue, error) {
+	cfg, err := parseMelangeConfig(contents)
+	if err != nil {
+		return basetypes.MapValue{}, err
+	}
+
+	byArch := make(map[string]attr.Value, len(archs))
+	for _, arch := range archs {
+		nw, err := substitutions(cfg, arch, "")
+		if err != nil {
+			return basetypes.MapValue{}, fmt.Errorf("%s: %w", arch, err)
+		}
+		vars := make(map[string]attr.Value, len(nw))
+		for k, v := range nw {
+			vars[strings.TrimSuffix(strings.TrimPrefix(k, "${{"), "}}")] = basetypes.NewStringValue(v)
+		}
+
+		var steps []attr.Value
+		var render func(path string, nw map[string]string, pipelines []melangeconfig.Pipeline) error
+		render = func(path string, nw map[string]string, pipelines []melangeconfig.Pipeline) error {
+			for i, p := range pipelines {
+				ppath := fmt.Sprintf("%s[%d]", path, i)
+				with := make(map[string]attr.Value, len(p.With))
+				for k, v := range p.With {
+					rv, err := util.MutateStringFromMap(nw, v)
+					if err != nil {
+						return fmt.Errorf("%s.with.%s: %w", ppath, k, err)
+					}
+					with[k] = basetypes.NewStringValue(rv)
+				}
+				runs := p.Runs
+				if p.Uses == "" {
+					// Steps that use other pipelines may reference their inputs,
+					// which are only known once the pipeline is loaded at build time.
+					stepnw := make(map[string]string, len(nw)+len(with))
+					for k, v := range nw {
+						stepnw[k] = v
+					}
+					for k, v := range with {
+						stepnw[fmt.Sprintf("${{inputs.%s}}", k)] = v.(basetypes.StringValue).ValueString()
+					}
+					rendered, err := util.MutateStringFromMap(stepnw, p.Runs)
+					if err != nil {
+						return fmt.Errorf("%s.runs: %w", ppath, err)
+					}
+					runs = rendered
+				}
+				steps = append(steps, basetypes.NewObjectValueMust(renderedStepType.AttrTypes, map[string]attr.Value{
+					"path": basetypes.NewStringValue(ppath),
+					"name": basetypes.NewStringValue(p.Name),
+					"uses": basetypes.NewStringValue(p.Uses),
+					"with": basetypes.NewMapValueMust(basetypes.StringType{}, with),
+					"runs": basetypes.NewStringValue(runs),
+				}))
+				if err := render(ppath+".pipeline", nw, p.Pipeline); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := render("pipeline", nw, cfg.Pipeline); err != nil {
+			return basetypes.MapValue{}, fmt.Errorf("%s: %w", arch, err)
+		}
+		for i, sp := range cfg.Subpackages {
+			spnw, err := substitutions(cfg, arch, sp.Name)
+			if err != nil {
+				return basetypes.MapValue{}, fmt.Errorf("%s: %w", arch, err)
+			}
+			if err := render(fmt.Sprintf("subpackages[%d].pipeline", i), spnw, sp.Pipeline); err != nil {
+				return basetypes.MapValue{}, fmt.Errorf("%s: %w", arch, err)
+			}
+		}
+
+		pipeline, diags := basetypes.NewListValue(renderedStepType, steps)
+		if diags.HasError() {
+			return basetypes.MapValue{}, fmt.Errorf("%s: %v", arch, diags.Errors())
+		}
+		byArch[arch.ToAPK()] = basetypes.NewObjectValueMust(renderedType.AttrTypes, map[string]attr.Value{
+			"vars":     basetypes.NewMapValueMust(basetypes.StringType{}, vars),
+			"pipeline": pipeline,
+		})
+	}
+	mv, diags := basetypes.NewMapValue(renderedType, byArch)
+	if diags.HasError() {
+		return basetypes.MapValue{}, fmt.Errorf("%v", diags.Errors())
+	}
+	return mv, nil
+}
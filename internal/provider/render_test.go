@@ -0,0 +1,77 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"testing"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+func TestRenderConfig(t *testing.T) {
+	config := `
+package:
+  name: hello
+  version: 2.12.1
+  epoch: 0
+vars:
+  mirror: https://ftp.gnu.org/gnu
+var-transforms:
+  - from: ${{package.version}}
+    match: ^(\d+\.\d+)\.\d+$
+    replace: $1
+    to: major-minor
+pipeline:
+  - uses: fetch
+    with:
+      uri: ${{vars.mirror}}/hello/hello-${{vars.major-minor}}.tar.gz
+  - runs: ./configure --host=${{host.triplet.gnu}} --prefix=${{inputs.prefix}}
+    with:
+      prefix: /usr
+subpackages:
+  - name: hello-doc
+    pipeline:
+      - runs: mkdir -p ${{targets.subpkgdir}}
+`
+	rendered, err := renderConfig([]byte(config), apkotypes.ParseArchitectures([]string{"x86_64", "aarch64"}))
+	if err != nil {
+		t.Fatalf("renderConfig: %v", err)
+	}
+
+	for arch, want := range map[string][]string{
+		"x86_64": {
+			"https://ftp.gnu.org/gnu/hello/hello-2.12.tar.gz",
+			"./configure --host=x86_64-pc-linux-gnu --prefix=/usr",
+			"mkdir -p /home/build/melange-out/hello-doc",
+		},
+		"aarch64": {
+			"https://ftp.gnu.org/gnu/hello/hello-2.12.tar.gz",
+			"./configure --host=aarch64-unknown-linux-gnu --prefix=/usr",
+			"mkdir -p /home/build/melange-out/hello-doc",
+		},
+	} {
+		obj, ok := rendered.Elements()[arch].(basetypes.ObjectValue)
+		if !ok {
+			t.Fatalf("no rendered config for %s", arch)
+		}
+		vars := obj.Attributes()["vars"].(basetypes.MapValue).Elements()
+		if got := vars["vars.major-minor"].(basetypes.StringValue).ValueString(); got != "2.12" {
+			t.Errorf("%s: vars.major-minor = %q, want %q", arch, got, "2.12")
+		}
+		steps := obj.Attributes()["pipeline"].(basetypes.ListValue).Elements()
+		if len(steps) != 3 {
+			t.Fatalf("%s: got %d steps, want 3", arch, len(steps))
+		}
+		fetch := steps[0].(basetypes.ObjectValue).Attributes()["with"].(basetypes.MapValue).Elements()["uri"]
+		if got := fetch.(basetypes.StringValue).ValueString(); got != want[0] {
+			t.Errorf("%s: fetch uri = %q, want %q", arch, got, want[0])
+		}
+		for i, step := range steps[1:] {
+			if got := step.(basetypes.ObjectValue).Attributes()["runs"].(basetypes.StringValue).ValueString(); got != want[i+1] {
+				t.Errorf("%s: step %d runs = %q, want %q", arch, i+1, got, want[i+1])
+			}
+		}
+	}
+}
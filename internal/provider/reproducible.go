@@ -0,0 +1,76 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// diffAPKFiles describes each path that differs between the data sections of
+// two builds of a package, in content, mode or mtime.
+func diffAPKFiles(a, b map[string]apkFile) []string {
+	paths := map[string]bool{}
+	for p := range a {
+		paths[p] = true
+	}
+	for p := range b {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var diffs []string
+	for _, p := range sorted {
+		fa, aok := a[p]
+		fb, bok := b[p]
+		switch {
+		case !bok:
+			diffs = append(diffs, fmt.Sprintf("%s: only in the first build", p))
+		case !aok:
+			diffs = append(diffs, fmt.Sprintf("%s: only in the second build", p))
+		default:
+			var what []string
+			if fa.Digest != fb.Digest {
+				what = append(what, fmt.Sprintf("content %s != %s", fa.Digest, fb.Digest))
+			}
+			if fa.Mode != fb.Mode {
+				what = append(what, fmt.Sprintf("mode %04o != %04o", fa.Mode, fb.Mode))
+			}
+			if !fa.ModTime.Equal(fb.ModTime) {
+				what = append(what, fmt.Sprintf("mtime %s != %s", fa.ModTime.UTC().Format(time.RFC3339), fb.ModTime.UTC().Format(time.RFC3339)))
+			}
+			if len(what) != 0 {
+				diffs = append(diffs, fmt.Sprintf("%s: %s", p, strings.Join(what, ", ")))
+			}
+		}
+	}
+	return diffs
+}
+
+// compareAPKs compares the data sections of two builds of a package,
+// returning an error describing the differences if they aren't identical.
+func compareAPKs(first, second string) error {
+	ha, fa, err := readAPK(first, nil)
+	if err != nil {
+		return err
+	}
+	hb, fb, err := readAPK(second, nil)
+	if err != nil {
+		return err
+	}
+	if ha == hb {
+		return nil
+	}
+	diffs := diffAPKFiles(fa, fb)
+	if len(diffs) == 0 {
+		diffs = []string{"no file differs in content, mode or mtime; check ownership and extended attributes"}
+	}
+	return fmt.Errorf("data section digests differ (%s != %s):\n    %s", ha, hb, strings.Join(diffs, "\n    "))
+}
@@ -0,0 +1,125 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testFile struct {
+	name, contents string
+	mode           int64
+	mtime          time.Time
+}
+
+// writeTestAPK writes an APK-like file with a signature, control and data
+// section. The control section is written without an end-of-archive marker,
+// like real APKs.
+func writeTestAPK(t *testing.T, path string, files []testFile) {
+	t.Helper()
+
+	section := func(files []testFile, trailer bool) []byte {
+		var tb bytes.Buffer
+		tw := tar.NewWriter(&tb)
+		for _, f := range files {
+			if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: f.mode, ModTime: f.mtime, Size: int64(len(f.contents)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte(f.contents)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		b := tb.Bytes()
+		if trailer {
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			b = tb.Bytes()
+		}
+		var zb bytes.Buffer
+		zw := gzip.NewWriter(&zb)
+		zw.Write(b)
+		zw.Close()
+		return zb.Bytes()
+	}
+
+	data := section(files, true)
+	pkginfo := fmt.Sprintf("pkgname = test\ndatahash = %x\n", sha256.Sum256(data))
+	var apk []byte
+	apk = append(apk, section([]testFile{{name: ".SIGN.RSA.key.rsa.pub", contents: "sig", mode: 0o644}}, false)...)
+	apk = append(apk, section([]testFile{{name: ".PKGINFO", contents: pkginfo, mode: 0o644}}, false)...)
+	apk = append(apk, data...)
+	if err := os.WriteFile(path, apk, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompareAPKs(t *testing.T) {
+	dir := t.TempDir()
+	epoch := time.Unix(0, 0)
+	files := []testFile{
+		{name: "usr/bin/hello", contents: "hello", mode: 0o755, mtime: epoch},
+		{name: "usr/share/hello.txt", contents: "hi", mode: 0o644, mtime: epoch},
+	}
+	first, same, different := filepath.Join(dir, "first.apk"), filepath.Join(dir, "same.apk"), filepath.Join(dir, "different.apk")
+	writeTestAPK(t, first, files)
+	writeTestAPK(t, same, files)
+	writeTestAPK(t, different, []testFile{
+		{name: "usr/bin/hello", contents: "hello", mode: 0o700, mtime: epoch},
+		{name: "usr/share/hello.txt", contents: "bye", mode: 0o644, mtime: epoch.Add(time.Hour)},
+		{name: "usr/share/extra", contents: "", mode: 0o644, mtime: epoch},
+	})
+
+	_, got, err := readAPK(first, nil)
+	if err != nil {
+		t.Fatalf("readAPK: %v", err)
+	}
+	if len(got) != 2 || got["usr/bin/hello"].Mode != 0o755 {
+		t.Errorf("unexpected data section files: %+v", got)
+	}
+
+	if err := compareAPKs(first, same); err != nil {
+		t.Errorf("identical builds differ: %v", err)
+	}
+
+	err = compareAPKs(first, different)
+	if err == nil {
+		t.Fatal("different builds compared equal")
+	}
+	for _, want := range []string{
+		"usr/bin/hello: mode 0755 != 0700",
+		"usr/share/extra: only in the second build",
+		"usr/share/hello.txt: content sha256:",
+		"mtime 1970-01-01T00:00:00Z != 1970-01-01T01:00:00Z",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't contain %q", err, want)
+		}
+	}
+}
+
+func TestAPKBuildDate(t *testing.T) {
+	// melange leaves builddate out of packages built at epoch 0.
+	path := filepath.Join(t.TempDir(), "epoch0.apk")
+	writeTestAPK(t, path, []testFile{{name: "usr/bin/hello", contents: "hello", mode: 0o755}})
+	date, err := apkBuildDate(path)
+	if err != nil {
+		t.Fatalf("apkBuildDate: %v", err)
+	}
+	if date != "" {
+		t.Errorf("apkBuildDate = %q, want epoch 0", date)
+	}
+}
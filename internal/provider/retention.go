@@ -0,0 +1,70 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	apkrepo "gitlab.alpinelinux.org/alpine/go/repository"
+)
+
+// pruneVersions applies the provider's retain_versions to the packages named
+// names in the index in dir, once current has been built into it: the
+// packages' older versions are removed from the index, and their APKs,
+// SBOMs, provenance and logs deleted. The caller must hold indexMu, so that
+// no other build adds to the index in between.
+func (r *BuildResource) pruneVersions(ctx context.Context, dir string, names []string, current string) error {
+	if r.popts.retainVersions <= 0 {
+		return nil
+	}
+	return r.rewriteIndex(ctx, dir, func(pkgs []*apkrepo.Package) ([]*apkrepo.Package, error) {
+		kept, pruned := prunePackages(pkgs, names, r.popts.retainVersions, current)
+		for _, p := range pruned {
+			apkPath := filepath.Join(dir, fmt.Sprintf("%s-%s.apk", p.Name, p.Version))
+			if err := removeAPK(apkPath); err != nil {
+				return nil, err
+			}
+			tflog.Info(ctx, fmt.Sprintf("pruned %s-%s from %s, keeping the latest %d versions", p.Name, p.Version, dir, r.popts.retainVersions))
+		}
+		return kept, nil
+	})
+}
+
+// prunePackages splits pkgs into those to keep and those to prune: every
+// version but the latest keep of each package named names. The current
+// version is always kept, even if newer ones were built before it.
+func prunePackages(pkgs []*apkrepo.Package, names []string, keep int, current string) ([]*apkrepo.Package, []*apkrepo.Package) {
+	versions := map[string][]string{}
+	for _, n := range names {
+		versions[n] = nil
+	}
+	for _, p := range pkgs {
+		if vs, ok := versions[p.Name]; ok {
+			versions[p.Name] = append(vs, p.Version)
+		}
+	}
+	retained := map[string]bool{}
+	for n, vs := range versions {
+		sort.Slice(vs, func(i, j int) bool { return compareAPKVersions(vs[i], vs[j]) > 0 })
+		for i, v := range vs {
+			if i < keep || v == current {
+				retained[n+"-"+v] = true
+			}
+		}
+	}
+
+	var kept, pruned []*apkrepo.Package
+	for _, p := range pkgs {
+		if _, ok := versions[p.Name]; ok && !retained[p.Name+"-"+p.Version] {
+			pruned = append(pruned, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	return kept, pruned
+}
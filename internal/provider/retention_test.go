@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	apkrepo "gitlab.alpinelinux.org/alpine/go/repository"
+)
+
+func TestPrunePackages(t *testing.T) {
+	pkgs := []*apkrepo.Package{
+		{Name: "foo", Version: "1.9-r0"},
+		{Name: "foo", Version: "1.10-r0"},
+		{Name: "foo", Version: "1.10-r1"},
+		{Name: "foo-dev", Version: "1.9-r0"},
+		{Name: "foo-dev", Version: "1.10-r1"},
+		{Name: "bar", Version: "1.0-r0"},
+		{Name: "bar", Version: "0.9-r0"},
+	}
+	names := func(pkgs []*apkrepo.Package) []string {
+		var s []string
+		for _, p := range pkgs {
+			s = append(s, p.Name+"-"+p.Version)
+		}
+		return s
+	}
+
+	for _, c := range []struct {
+		desc       string
+		keep       int
+		current    string
+		wantPruned []string
+	}{{
+		desc:       "keep latest",
+		keep:       1,
+		current:    "1.10-r1",
+		wantPruned: []string{"foo-1.9-r0", "foo-1.10-r0", "foo-dev-1.9-r0"},
+	}, {
+		desc:       "keep two",
+		keep:       2,
+		current:    "1.10-r1",
+		wantPruned: []string{"foo-1.9-r0"},
+	}, {
+		desc:       "keep current",
+		keep:       1,
+		current:    "1.9-r0",
+		wantPruned: []string{"foo-1.10-r0"},
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			kept, pruned := prunePackages(pkgs, []string{"foo", "foo-dev"}, c.keep, c.current)
+			if got := names(pruned); !reflect.DeepEqual(got, c.wantPruned) {
+				t.Errorf("pruned %v, want %v", got, c.wantPruned)
+			}
+			if len(kept)+len(pruned) != len(pkgs) {
+				t.Errorf("kept %v and pruned %v of %d packages", names(kept), names(pruned), len(pkgs))
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const defaultRetryBackoff = 30 * time.Second
+
+var retryBlock = schema.SingleNestedBlock{
+	MarkdownDescription: "Retry policy for transient build failures, applied to each architecture separately. Builds that time out are not retried.",
+	Attributes: map[string]schema.Attribute{
+		"max_attempts": schema.Int64Attribute{
+			MarkdownDescription: "The maximum number of times to attempt each build, including the first. Defaults to 1, which disables retries.",
+			Optional:            true,
+		},
+		"backoff": schema.StringAttribute{
+			MarkdownDescription: "How long to wait before the first retry, as a duration string like `\"30s\"`. The wait doubles after each attempt. Defaults to `\"30s\"`.",
+			Optional:            true,
+		},
+		"retryable_patterns": schema.ListAttribute{
+			MarkdownDescription: "Regular expressions matched against the build error and log. A failed build is only retried if one of them matches. If unset, all failures are retried.",
+			Optional:            true,
+			ElementType:         types.StringType,
+		},
+	},
+}
+
+type retryModel struct {
+	MaxAttempts       types.Int64  `tfsdk:"max_attempts"`
+	Backoff           types.String `tfsdk:"backoff"`
+	RetryablePatterns types.List   `tfsdk:"retryable_patterns"`
+}
+
+// retryPolicy describes how failed builds are retried.
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+	patterns    []*regexp.Regexp
+}
+
+// parseRetryPolicy returns the policy described by a `retry` block. If the
+// block isn't set, builds are attempted once.
+func parseRetryPolicy(ctx context.Context, obj types.Object) (retryPolicy, error) {
+	p := retryPolicy{maxAttempts: 1, backoff: defaultRetryBackoff}
+	if obj.IsNull() || obj.IsUnknown() {
+		return p, nil
+	}
+	var m retryModel
+	if diags := obj.As(ctx, &m, basetypes.ObjectAsOptions{}); diags.HasError() {
+		return p, fmt.Errorf("reading retry: %v", diags.Errors())
+	}
+
+	if !m.MaxAttempts.IsNull() {
+		if m.MaxAttempts.ValueInt64() < 1 {
+			return p, fmt.Errorf("retry.max_attempts: must be at least 1, got %d", m.MaxAttempts.ValueInt64())
+		}
+		p.maxAttempts = int(m.MaxAttempts.ValueInt64())
+	}
+	if !m.Backoff.IsNull() {
+		d, err := time.ParseDuration(m.Backoff.ValueString())
+		if err != nil {
+			return p, fmt.Errorf("retry.backoff: %w", err)
+		}
+		if d < 0 {
+			return p, fmt.Errorf("retry.backoff: %q must not be negative", m.Backoff.ValueString())
+		}
+		p.backoff = d
+	}
+	var patterns []string
+	if diags := m.RetryablePatterns.ElementsAs(ctx, &patterns, false); diags.HasError() {
+		return p, fmt.Errorf("reading retry.retryable_patterns: %v", diags.Errors())
+	}
+	for i, pat := range patterns {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return p, fmt.Errorf("retry.retryable_patterns[%d]: %w", i, err)
+		}
+		p.patterns = append(p.patterns, re)
+	}
+	return p, nil
+}
+
+// retryable reports whether a failed attempt should be retried, given its
+// error and log output.
+func (p retryPolicy) retryable(err error, log []byte) bool {
+	if len(p.patterns) == 0 {
+		return true
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(err.Error()) || re.Match(log) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryErrors describes every failed attempt of a build.
+type retryErrors struct {
+	what string
+	errs []error
+}
+
+func (e *retryErrors) Error() string {
+	if len(e.errs) == 1 {
+		return e.errs[0].Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s failed after %d attempts:", e.what, len(e.errs))
+	for i, err := range e.errs {
+		fmt.Fprintf(&b, "\n  attempt %d: %v", i+1, err)
+	}
+	return b.String()
+}
+
+func (e *retryErrors) Unwrap() []error { return e.errs }
+
+// run calls fn until it succeeds, the policy's attempts are used up, or the
+// failure isn't retryable. fn returns the log output of the attempt, which is
+// matched against the retryable patterns along with its error.
+func (p retryPolicy) run(ctx context.Context, what string, fn func(ctx context.Context, attempt int) ([]byte, error)) error {
+	errs := &retryErrors{what: what}
+	backoff := p.backoff
+	for attempt := 1; ; attempt++ {
+		log, err := fn(ctx, attempt)
+		if err == nil {
+			if attempt > 1 {
+				tflog.Info(ctx, fmt.Sprintf("%s succeeded on attempt %d of %d", what, attempt, p.maxAttempts))
+			}
+			return nil
+		}
+		errs.errs = append(errs.errs, err)
+		tflog.Warn(ctx, fmt.Sprintf("%s failed on attempt %d of %d: %v", what, attempt, p.maxAttempts, err))
+
+		if attempt >= p.maxAttempts || ctx.Err() != nil {
+			return errs
+		}
+		if !p.retryable(err, log) {
+			tflog.Info(ctx, fmt.Sprintf("%s failure is not retryable", what))
+			return errs
+		}
+
+		tflog.Info(ctx, fmt.Sprintf("retrying %s in %s", what, backoff))
+		select {
+		case <-ctx.Done():
+			errs.errs[len(errs.errs)-1] = fmt.Errorf("%w (stopped waiting to retry: %w)", err, ctx.Err())
+			return errs
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
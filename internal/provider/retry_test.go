@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	for _, c := range []struct {
+		desc         string
+		policy       retryPolicy
+		results      []error
+		log          string
+		wantAttempts int
+		wantErr      string
+	}{{
+		desc:         "no retries",
+		policy:       retryPolicy{maxAttempts: 1},
+		results:      []error{errors.New("boom")},
+		wantAttempts: 1,
+		wantErr:      "boom",
+	}, {
+		desc:         "succeeds on retry",
+		policy:       retryPolicy{maxAttempts: 3},
+		results:      []error{errors.New("boom"), nil},
+		wantAttempts: 2,
+	}, {
+		desc:         "attempts exhausted",
+		policy:       retryPolicy{maxAttempts: 2},
+		results:      []error{errors.New("first"), errors.New("second")},
+		wantAttempts: 2,
+		wantErr:      "building x failed after 2 attempts:\n  attempt 1: first\n  attempt 2: second",
+	}, {
+		desc:         "pattern matches error",
+		policy:       retryPolicy{maxAttempts: 3, patterns: []*regexp.Regexp{regexp.MustCompile(`connection reset`)}},
+		results:      []error{errors.New("fetch: connection reset by peer"), nil},
+		wantAttempts: 2,
+	}, {
+		desc:         "pattern matches log",
+		policy:       retryPolicy{maxAttempts: 3, patterns: []*regexp.Regexp{regexp.MustCompile(`Temporary failure in name resolution`)}},
+		results:      []error{errors.New("unable to run pipeline"), nil},
+		log:          "wget: bad address: Temporary failure in name resolution",
+		wantAttempts: 2,
+	}, {
+		desc:         "pattern doesn't match",
+		policy:       retryPolicy{maxAttempts: 3, patterns: []*regexp.Regexp{regexp.MustCompile(`connection reset`)}},
+		results:      []error{errors.New("make check failed")},
+		wantAttempts: 1,
+		wantErr:      "make check failed",
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			var attempts int
+			err := c.policy.run(context.Background(), "building x", func(ctx context.Context, attempt int) ([]byte, error) {
+				attempts++
+				if attempt != attempts {
+					t.Errorf("attempt = %d, want %d", attempt, attempts)
+				}
+				return []byte(c.log), c.results[attempt-1]
+			})
+			if attempts != c.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, c.wantAttempts)
+			}
+			switch {
+			case err == nil && c.wantErr != "":
+				t.Errorf("got no error, want %q", c.wantErr)
+			case err != nil && err.Error() != c.wantErr:
+				t.Errorf("got error %q, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := retryPolicy{maxAttempts: 3}
+	err := p.run(ctx, "building x", func(ctx context.Context, attempt int) ([]byte, error) {
+		cancel()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if strings.Contains(err.Error(), "attempt 2") {
+		t.Errorf("cancelled build was retried: %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	apkolog "chainguard.dev/apko/pkg/log"
+	"chainguard.dev/melange/pkg/build"
+	"chainguard.dev/melange/pkg/container"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	fwpath "github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+// runnerNames are the runners the vendored version of melange supports.
+var runnerNames = []string{container.BubblewrapName, container.DockerName, container.KubernetesName, container.LimaName}
+
+var extraMountsAttribute = schema.ListAttribute{
+	Description: "Additional host paths to bind mount into the build guest, as `source:destination`, where destination is an absolute path in the guest.",
+	Optional:    true,
+	ElementType: types.StringType,
+}
+
+// runnerBlocks are the provider's runner-specific configuration blocks.
+var runnerBlocks = map[string]schema.Block{
+	container.DockerName: schema.SingleNestedBlock{
+		Description: "Build with the docker runner. Conflicts with other runners.",
+		Attributes: map[string]schema.Attribute{
+			"host": schema.StringAttribute{
+				Description: "The Docker daemon to use, like `unix:///var/run/docker.sock` or `tcp://host:2376`. Sets `DOCKER_HOST` for the provider. Defaults to `DOCKER_HOST`, or the local daemon.",
+				Optional:    true,
+			},
+			"extra_mounts": extraMountsAttribute,
+		},
+	},
+	container.BubblewrapName: schema.SingleNestedBlock{
+		Description: "Build with the bubblewrap runner, which runs builds in unprivileged namespaces without a daemon. Requires `bwrap` on the `PATH`. Conflicts with other runners.",
+		Attributes: map[string]schema.Attribute{
+			"extra_mounts": extraMountsAttribute,
+		},
+	},
+}
+
+type dockerModel struct {
+	Host        types.String `tfsdk:"host"`
+	ExtraMounts []string     `tfsdk:"extra_mounts"`
+}
+
+type bubblewrapModel struct {
+	ExtraMounts []string `tfsdk:"extra_mounts"`
+}
+
+// configureRunner validates the provider's runner configuration, returning
+// the runner to use and the extra mounts to add to its guests.
+//
+// A runner that's chosen explicitly, rather than by default, must be usable.
+func configureRunner(ctx context.Context, data ProviderModel) (string, []container.BindMount, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	name := data.Runner.ValueString()
+
+	var (
+		block  string
+		mounts []string
+	)
+	if !data.Docker.IsNull() {
+		var docker dockerModel
+		if diags.Append(data.Docker.As(ctx, &docker, basetypes.ObjectAsOptions{})...); diags.HasError() {
+			return "", nil, diags
+		}
+		block, mounts = container.DockerName, docker.ExtraMounts
+		if host := docker.Host.ValueString(); host != "" {
+			if err := os.Setenv("DOCKER_HOST", host); err != nil {
+				diags.AddAttributeError(fwpath.Root("docker").AtName("host"), "Invalid Docker host", err.Error())
+				return "", nil, diags
+			}
+		}
+	}
+	if !data.Bubblewrap.IsNull() {
+		if block != "" {
+			diags.AddAttributeError(fwpath.Root("bubblewrap"), "Conflicting runner configuration", "Only one of the docker and bubblewrap blocks can be set.")
+			return "", nil, diags
+		}
+		var bwrap bubblewrapModel
+		if diags.Append(data.Bubblewrap.As(ctx, &bwrap, basetypes.ObjectAsOptions{})...); diags.HasError() {
+			return "", nil, diags
+		}
+		block, mounts = container.BubblewrapName, bwrap.ExtraMounts
+	}
+	if block != "" {
+		if name != "" && name != block {
+			diags.AddAttributeError(fwpath.Root("runner"), "Conflicting runner configuration", fmt.Sprintf("runner is %q, but the %s block is set.", name, block))
+			return "", nil, diags
+		}
+		name = block
+	}
+
+	explicit := name != ""
+	if !explicit {
+		name = container.DockerName
+	}
+	if !validRunner(name) {
+		detail := fmt.Sprintf("Unknown runner %q.", name)
+		if name == "qemu" {
+			detail = "The qemu runner isn't supported by the version of melange this provider is built with."
+		}
+		diags.AddAttributeError(fwpath.Root("runner"), "Unsupported runner", fmt.Sprintf("%s Supported runners are: %s.", detail, strings.Join(runnerNames, ", ")))
+		return "", nil, diags
+	}
+
+	binds := make([]container.BindMount, 0, len(mounts))
+	for i, m := range mounts {
+		bind, err := parseMount(m)
+		if err != nil {
+			diags.AddAttributeError(fwpath.Root(block).AtName("extra_mounts").AtListIndex(i), "Invalid mount", err.Error())
+			continue
+		}
+		binds = append(binds, bind)
+	}
+	if diags.HasError() {
+		return "", nil, diags
+	}
+
+	if explicit {
+		if err := runnerUsable(ctx, name); err != nil {
+			diags.AddError("Runner unavailable", err.Error())
+			return "", nil, diags
+		}
+	}
+	return name, binds, diags
+}
+
+func validRunner(name string) bool {
+	for _, n := range runnerNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMount parses a source:destination bind mount.
+func parseMount(m string) (container.BindMount, error) {
+	src, dst, ok := strings.Cut(m, ":")
+	if !ok || src == "" || dst == "" {
+		return container.BindMount{}, fmt.Errorf("%q isn't of the form source:destination", m)
+	}
+	if !path.IsAbs(dst) {
+		return container.BindMount{}, fmt.Errorf("destination %q must be an absolute path", dst)
+	}
+	if _, err := os.Stat(src); err != nil {
+		return container.BindMount{}, fmt.Errorf("source %q: %w", src, err)
+	}
+	return container.BindMount{Source: src, Destination: dst}, nil
+}
+
+// runnerUsable checks that the runner's daemon or binary is available, the
+// same way melange does before a build.
+func runnerUsable(ctx context.Context, name string) error {
+	var buf bytes.Buffer
+	logger := apkolog.NewLogger(&buf)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	switch name {
+	case container.DockerName:
+		if !container.DockerRunner(logger).TestUsability(ctx) {
+			host := os.Getenv("DOCKER_HOST")
+			if host == "" {
+				host = "the default socket"
+			}
+			return fmt.Errorf("the docker runner can't reach the Docker daemon at %s: %s", host, strings.TrimSpace(buf.String()))
+		}
+	case container.BubblewrapName:
+		if !container.BubblewrapRunner(logger).TestUsability(ctx) {
+			return fmt.Errorf("the bubblewrap runner needs bwrap, which isn't on the PATH")
+		}
+	}
+	return nil
+}
+
+// mountingRunner adds extra bind mounts to every guest its runner starts.
+type mountingRunner struct {
+	container.Runner
+	mounts []container.BindMount
+}
+
+func (r mountingRunner) StartPod(ctx context.Context, cfg *container.Config) error {
+	cfg.Mounts = append(cfg.Mounts, r.mounts...)
+	return r.Runner.StartPod(ctx, cfg)
+}
+
+// installedRecorder records the packages installed in a build's guest when
+// its pod starts, since melange removes the guest once the build is done.
+type installedRecorder struct {
+	container.Runner
+	bc        *build.Build
+	installed []string // name=version
+}
+
+func (r *installedRecorder) StartPod(ctx context.Context, cfg *container.Config) error {
+	installed, err := readInstalled(r.bc.GuestDir)
+	if err != nil {
+		return fmt.Errorf("reading installed packages: %w", err)
+	}
+	r.installed = installed
+	return r.Runner.StartPod(ctx, cfg)
+}
+
+// newBuild creates a build context with the provider's runner configuration,
+// whose logs don't contain the provider's secrets.
+func (o ProviderOpts) newBuild(ctx context.Context, opts ...build.Option) (*build.Build, error) {
+	bc, err := build.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	redactLogs(bc, o.secrets)
+	if len(o.mounts) != 0 {
+		bc.Runner = mountingRunner{Runner: bc.Runner, mounts: o.mounts}
+	}
+	return bc, nil
+}
@@ -0,0 +1,89 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+func TestParseMount(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range []struct {
+		mount, wantErr string
+	}{
+		{mount: dir + ":/mnt/data"},
+		{mount: dir, wantErr: "isn't of the form source:destination"},
+		{mount: dir + ":mnt", wantErr: "must be an absolute path"},
+		{mount: dir + "/missing:/mnt", wantErr: "no such file or directory"},
+	} {
+		m, err := parseMount(c.mount)
+		switch {
+		case c.wantErr == "" && err != nil:
+			t.Errorf("parseMount(%q): %v", c.mount, err)
+		case c.wantErr == "" && (m.Source != dir || m.Destination != "/mnt/data"):
+			t.Errorf("parseMount(%q) = %+v", c.mount, m)
+		case c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)):
+			t.Errorf("parseMount(%q) = %v, want error containing %q", c.mount, err, c.wantErr)
+		}
+	}
+}
+
+func TestConfigureRunner(t *testing.T) {
+	dockerType := map[string]attr.Type{"host": types.StringType, "extra_mounts": types.ListType{ElemType: types.StringType}}
+	bwrapType := map[string]attr.Type{"extra_mounts": types.ListType{ElemType: types.StringType}}
+	bwrap := types.ObjectValueMust(bwrapType, map[string]attr.Value{
+		"extra_mounts": types.ListValueMust(types.StringType, []attr.Value{types.StringValue("relative")}),
+	})
+	docker := types.ObjectValueMust(dockerType, map[string]attr.Value{
+		"host":         types.StringNull(),
+		"extra_mounts": types.ListNull(types.StringType),
+	})
+	nullDocker, nullBwrap := types.ObjectNull(dockerType), types.ObjectNull(bwrapType)
+
+	for _, c := range []struct {
+		desc               string
+		runner             string
+		docker, bubblewrap basetypes.ObjectValue
+		want, wantErr      string
+	}{
+		{desc: "default", want: "docker"},
+		{desc: "qemu", runner: "qemu", wantErr: "qemu runner isn't supported"},
+		{desc: "unknown", runner: "podman", wantErr: `Unknown runner "podman"`},
+		{desc: "both blocks", docker: docker, bubblewrap: bwrap, wantErr: "Only one of the docker and bubblewrap blocks"},
+		{desc: "runner conflicts with block", runner: "docker", bubblewrap: bwrap, wantErr: `runner is "docker", but the bubblewrap block is set`},
+		{desc: "bad mount", bubblewrap: bwrap, wantErr: `"relative" isn't of the form source:destination`},
+	} {
+		t.Run(c.desc, func(t *testing.T) {
+			data := ProviderModel{Runner: types.StringValue(c.runner), Docker: nullDocker, Bubblewrap: nullBwrap}
+			if !c.docker.IsNull() {
+				data.Docker = c.docker
+			}
+			if !c.bubblewrap.IsNull() {
+				data.Bubblewrap = c.bubblewrap
+			}
+			got, _, diags := configureRunner(context.Background(), data)
+			if c.wantErr == "" {
+				if diags.HasError() {
+					t.Fatalf("configureRunner: %v", diags)
+				}
+				if got != c.want {
+					t.Errorf("got runner %q, want %q", got, c.want)
+				}
+				return
+			}
+			if !diags.HasError() {
+				t.Fatalf("configureRunner succeeded, want error containing %q", c.wantErr)
+			}
+			if detail := diags.Errors()[0].Detail(); !strings.Contains(detail, c.wantErr) {
+				t.Errorf("got error %q, want it to contain %q", detail, c.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+// sbomDir is where melange embeds the SPDX SBOM in each APK it builds.
+const sbomDir = "var/lib/db/sbom"
+
+// spdxDocument is the subset of an SPDX document exposed by melange_build.
+type spdxDocument struct {
+	Packages []struct {
+		Name             string `json:"name"`
+		VersionInfo      string `json:"versionInfo"`
+		LicenseDeclared  string `json:"licenseDeclared"`
+		LicenseConcluded string `json:"licenseConcluded"`
+		DownloadLocation string `json:"downloadLocation"`
+	} `json:"packages"`
+}
+
+var sbomPackageType = basetypes.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"name":              basetypes.StringType{},
+		"version":           basetypes.StringType{},
+		"license":           basetypes.StringType{},
+		"download_location": basetypes.StringType{},
+	},
+}
+
+var sbomType = basetypes.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"arch":               basetypes.StringType{},
+		"package":            basetypes.StringType{},
+		"path":               basetypes.StringType{},
+		"packages":           basetypes.ListType{ElemType: sbomPackageType},
+		"licenses":           basetypes.ListType{ElemType: basetypes.StringType{}},
+		"download_locations": basetypes.ListType{ElemType: basetypes.StringType{}},
+	},
+}
+
+// extractSBOM copies the SBOM embedded in the APK to a .spdx.json file next
+// to it, returning the path it was written to and its contents.
+func extractSBOM(apkPath string) (string, []byte, error) {
+	name := strings.TrimSuffix(filepath.Base(apkPath), ".apk") + ".spdx.json"
+	want := path.Join(sbomDir, name)
+	_, files, err := readAPK(apkPath, func(n string) bool { return n == want })
+	if err != nil {
+		return "", nil, err
+	}
+	f, ok := files[want]
+	if !ok {
+		return "", nil, fmt.Errorf("%s has no SBOM at /%s", apkPath, want)
+	}
+	out := filepath.Join(filepath.Dir(apkPath), name)
+	if err := os.WriteFile(out, f.Data, 0o644); err != nil {
+		return "", nil, fmt.Errorf("writing SBOM: %w", err)
+	}
+	return out, f.Data, nil
+}
+
+// sbomValue parses an SPDX SBOM into the attributes exposed by melange_build.
+// NOASSERTION and NONE are left out of the license and download location
+// summaries.
+func sbomValue(arch, pkg, sbomPath string, contents []byte) (basetypes.ObjectValue, error) {
+	var doc spdxDocument
+	if err := json.Unmarshal(contents, &doc); err != nil {
+		return basetypes.ObjectValue{}, fmt.Errorf("parsing SBOM %s: %w", sbomPath, err)
+	}
+
+	licenses, locations := map[string]bool{}, map[string]bool{}
+	pkgs := make([]attr.Value, 0, len(doc.Packages))
+	for _, p := range doc.Packages {
+		license := p.LicenseDeclared
+		if !spdxAsserted(license) {
+			license = p.LicenseConcluded
+		}
+		if spdxAsserted(license) {
+			licenses[license] = true
+		}
+		if spdxAsserted(p.DownloadLocation) {
+			locations[p.DownloadLocation] = true
+		}
+		pkgs = append(pkgs, basetypes.NewObjectValueMust(sbomPackageType.AttrTypes, map[string]attr.Value{
+			"name":              basetypes.NewStringValue(p.Name),
+			"version":           basetypes.NewStringValue(p.VersionInfo),
+			"license":           basetypes.NewStringValue(license),
+			"download_location": basetypes.NewStringValue(p.DownloadLocation),
+		}))
+	}
+
+	return basetypes.NewObjectValueMust(sbomType.AttrTypes, map[string]attr.Value{
+		"arch":               basetypes.NewStringValue(arch),
+		"package":            basetypes.NewStringValue(pkg),
+		"path":               basetypes.NewStringValue(sbomPath),
+		"packages":           basetypes.NewListValueMust(sbomPackageType, pkgs),
+		"licenses":           sortedStrings(licenses),
+		"download_locations": sortedStrings(locations),
+	}), nil
+}
+
+func spdxAsserted(v string) bool {
+	return v != "" && v != "NOASSERTION" && v != "NONE"
+}
+
+func sortedStrings(set map[string]bool) basetypes.ListValue {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	vals := make([]attr.Value, 0, len(keys))
+	for _, k := range keys {
+		vals = append(vals, basetypes.NewStringValue(k))
+	}
+	return basetypes.NewListValueMust(basetypes.StringType{}, vals)
+}
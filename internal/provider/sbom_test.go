@@ -0,0 +1,59 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+func TestExtractSBOM(t *testing.T) {
+	dir := t.TempDir()
+	apk := filepath.Join(dir, "hello-1.0.0-r2.apk")
+	sbom := `{
+  "packages": [
+    {"name": "hello", "versionInfo": "1.0.0-r2", "licenseDeclared": "Apache-2.0", "licenseConcluded": "NOASSERTION", "downloadLocation": "NOASSERTION"},
+    {"name": "libfoo", "versionInfo": "3.1", "licenseDeclared": "NOASSERTION", "licenseConcluded": "MIT", "downloadLocation": "https://example.com/libfoo-3.1.tar.gz"}
+  ]
+}`
+	writeTestAPK(t, apk, []testFile{
+		{name: "usr/bin/hello", contents: "hello", mode: 0o755},
+		{name: "var/lib/db/sbom/hello-1.0.0-r2.spdx.json", contents: sbom, mode: 0o644},
+	})
+
+	path, contents, err := extractSBOM(apk)
+	if err != nil {
+		t.Fatalf("extractSBOM: %v", err)
+	}
+	if want := filepath.Join(dir, "hello-1.0.0-r2.spdx.json"); path != want {
+		t.Errorf("got path %q, want %q", path, want)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != sbom {
+		t.Errorf("SBOM wasn't written: %q, %v", b, err)
+	}
+
+	v, err := sbomValue("x86_64", "hello", path, contents)
+	if err != nil {
+		t.Fatalf("sbomValue: %v", err)
+	}
+	attrs := v.Attributes()
+	if got := attrs["licenses"].String(); got != `["Apache-2.0","MIT"]` {
+		t.Errorf("got licenses %s", got)
+	}
+	if got := attrs["download_locations"].String(); got != `["https://example.com/libfoo-3.1.tar.gz"]` {
+		t.Errorf("got download_locations %s", got)
+	}
+	if pkgs := attrs["packages"].(basetypes.ListValue).Elements(); len(pkgs) != 2 {
+		t.Errorf("got %d packages, want 2", len(pkgs))
+	}
+
+	noSBOM := filepath.Join(dir, "nosbom-1.0.0-r0.apk")
+	writeTestAPK(t, noSBOM, []testFile{{name: "usr/bin/hello", contents: "hello", mode: 0o755}})
+	if _, _, err := extractSBOM(noSBOM); err == nil {
+		t.Errorf("extractSBOM succeeded on an APK without an SBOM")
+	}
+}
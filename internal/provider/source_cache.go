@@ -0,0 +1,246 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	melangeconfig "chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/util"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+// cacheEntry is a source fetched by a melange pipeline, along with the name
+// of the file or directory it's cached as in the melange cache directory.
+//
+// melange mounts the cache directory at /var/cache/melange in the build
+// guest, where the fetch pipeline looks for files named by their expected
+// checksum. git-checkout sources are keyed by their expected commit, but
+// aren't cached: melange's git-checkout pipeline always clones from the
+// network, and never looks in the cache.
+type cacheEntry struct {
+	Kind string // fetch or git-checkout
+	Key  string // e.g. sha256:<hex> or git:<commit>; empty if the source can't be cached
+	URI  string
+	Ref  string // the tag or branch of a git-checkout
+	Path string // the key path of the pipeline step
+}
+
+var cacheEntryType = basetypes.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"kind": basetypes.StringType{},
+		"key":  basetypes.StringType{},
+		"uri":  basetypes.StringType{},
+		"path": basetypes.StringType{},
+	},
+}
+
+func (e cacheEntry) value() basetypes.ObjectValue {
+	return basetypes.NewObjectValueMust(cacheEntryType.AttrTypes, map[string]attr.Value{
+		"kind": basetypes.NewStringValue(e.Kind),
+		"key":  basetypes.NewStringValue(e.Key),
+		"uri":  basetypes.NewStringValue(e.URI),
+		"path": basetypes.NewStringValue(e.Path),
+	})
+}
+
+// sourceCacheEntries returns the sources fetched by the configuration's fetch
+// and git-checkout steps when built for any of archs, with substitutions
+// evaluated. Entries are deduplicated by key.
+func sourceCacheEntries(contents []byte, archs []apkotypes.Architecture) ([]cacheEntry, error) {
+	cfg, err := parseMelangeConfig(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var entries []cacheEntry
+	var walk func(path string, nw map[string]string, pipelines []melangeconfig.Pipeline) error
+	walk = func(path string, nw map[string]string, pipelines []melangeconfig.Pipeline) error {
+		for i, p := range pipelines {
+			ppath := fmt.Sprintf("%s[%d]", path, i)
+			if p.Uses == "fetch" || p.Uses == "git-checkout" {
+				with := make(map[string]string, len(p.With))
+				for k, v := range p.With {
+					rv, err := util.MutateStringFromMap(nw, v)
+					if err != nil {
+						return fmt.Errorf("%s.with.%s: %w", ppath, k, err)
+					}
+					with[k] = rv
+				}
+				e := cacheEntry{Kind: p.Uses, Path: ppath}
+				switch p.Uses {
+				case "fetch":
+					e.URI = with["uri"]
+					if sum := with["expected-sha256"]; sum != "" {
+						e.Key = "sha256:" + sum
+					} else if sum := with["expected-sha512"]; sum != "" {
+						e.Key = "sha512:" + sum
+					}
+				case "git-checkout":
+					e.URI = with["repository"]
+					e.Ref = with["tag"]
+					if e.Ref == "" {
+						e.Ref = with["branch"]
+					}
+					if commit := with["expected-commit"]; commit != "" {
+						e.Key = "git:" + commit
+					}
+				}
+				id := e.Key
+				if id == "" {
+					id = e.Kind + " " + e.URI
+				}
+				if !seen[id] {
+					seen[id] = true
+					entries = append(entries, e)
+				}
+			}
+			if err := walk(ppath+".pipeline", nw, p.Pipeline); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, arch := range archs {
+		nw, err := substitutions(cfg, arch, "")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", arch, err)
+		}
+		if err := walk("pipeline", nw, cfg.Pipeline); err != nil {
+			return nil, fmt.Errorf("%s: %w", arch, err)
+		}
+		for i, sp := range cfg.Subpackages {
+			spnw, err := substitutions(cfg, arch, sp.Name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", arch, err)
+			}
+			if err := walk(fmt.Sprintf("subpackages[%d].pipeline", i), spnw, sp.Pipeline); err != nil {
+				return nil, fmt.Errorf("%s: %w", arch, err)
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+// cacheable reports whether builds look for the entry in the cache
+// directory, which only the fetch pipeline does.
+func (e cacheEntry) cacheable() bool {
+	return e.Kind == "fetch"
+}
+
+// missingCacheEntries checks that every source can be served from the cache
+// directory without network access, returning a description of each that
+// can't. Sources of git-checkout steps are always cloned from the network, so
+// they're always missing.
+func missingCacheEntries(cacheDir string, entries []cacheEntry) []string {
+	var missing []string
+	for _, e := range entries {
+		switch {
+		case !e.cacheable():
+			missing = append(missing, networkOnly(e))
+		case e.Key == "":
+			missing = append(missing, fmt.Sprintf("%s: %s %s has no expected checksum to cache it by", e.Path, e.Kind, e.URI))
+		case !e.cached(cacheDir):
+			missing = append(missing, fmt.Sprintf("%s: %s (%s) is not cached", e.Path, e.Key, e.URI))
+		}
+	}
+	return missing
+}
+
+// networkOnlyEntries returns a description of each source that can't be
+// built offline whatever's in the cache, which is each git-checkout.
+func networkOnlyEntries(entries []cacheEntry) []string {
+	var out []string
+	for _, e := range entries {
+		if !e.cacheable() {
+			out = append(out, networkOnly(e))
+		}
+	}
+	return out
+}
+
+func networkOnly(e cacheEntry) string {
+	return fmt.Sprintf("%s: %s of %s always clones from the network", e.Path, e.Kind, e.URI)
+}
+
+// cached reports whether the entry is already in the cache directory.
+func (e cacheEntry) cached(cacheDir string) bool {
+	if e.Key == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(cacheDir, e.Key))
+	return err == nil
+}
+
+// fetch downloads the entry into the cache directory, verifying its checksum
+// before making it visible under its key.
+func (e cacheEntry) fetch(ctx context.Context, cacheDir string) error {
+	if !e.cacheable() {
+		return fmt.Errorf("%s: %s sources aren't cached", e.Path, e.Kind)
+	}
+	if e.Key == "" {
+		return fmt.Errorf("%s: %s %s has no expected checksum to cache it by", e.Path, e.Kind, e.URI)
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return err
+	}
+	return e.fetchFile(ctx, cacheDir)
+}
+
+func (e cacheEntry) fetchFile(ctx context.Context, cacheDir string) error {
+	algo, want, _ := strings.Cut(e.Key, ":")
+	var h hash.Hash
+	switch algo {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.URI, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching %s: %w", e.URI, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", e.URI, resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(cacheDir, ".fetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("fetching %s: %w", e.URI, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("fetching %s: expected %s %s, got %s", e.URI, algo, want, got)
+	}
+	return os.Rename(tmp.Name(), filepath.Join(cacheDir, e.Key))
+}
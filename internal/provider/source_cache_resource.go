@@ -0,0 +1,263 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ resource.Resource = &SourceCacheResource{}
+
+func NewSourceCacheResource() resource.Resource {
+	return &SourceCacheResource{}
+}
+
+// SourceCacheResource defines the resource implementation.
+type SourceCacheResource struct {
+	popts ProviderOpts
+}
+
+// SourceCacheResourceModel describes the resource data model.
+type SourceCacheResourceModel struct {
+	ConfigContents types.List   `tfsdk:"config_contents"`
+	Archs          types.List   `tfsdk:"archs"`
+	Entries        types.List   `tfsdk:"entries"`
+	Id             types.String `tfsdk:"id"`
+}
+
+func (r *SourceCacheResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_source_cache"
+}
+
+func (r *SourceCacheResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "Pre-fetches the sources referenced by `fetch` steps of melange configurations into the provider's cache directory, keyed by their expected checksum, so builds can run with the provider's `offline` setting. Sources of `git-checkout` steps aren't cached, since melange's `git-checkout` pipeline always clones from the network, so builds that use it fail to plan offline.",
+
+		Attributes: map[string]schema.Attribute{
+			"config_contents": schema.ListAttribute{
+				MarkdownDescription: "The raw contents of the melange configurations whose sources should be cached.",
+				Required:            true,
+				ElementType:         types.StringType,
+			},
+			"archs": schema.ListAttribute{
+				MarkdownDescription: "Architectures to evaluate arch-dependent substitutions in source URIs for. Defaults to each configuration's architectures, or the provider's `default_archs`.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"entries": schema.ListAttribute{
+				MarkdownDescription: "The cached sources, with their `kind` (`fetch`), cache `key`, `uri` and the key `path` of the step that references them.",
+				Computed:            true,
+				ElementType:         cacheEntryType,
+			},
+			"id": schema.StringAttribute{
+				Computed:            true,
+				MarkdownDescription: "Identifier of the resource",
+				PlanModifiers:       []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+		},
+	}
+}
+
+func (r *SourceCacheResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+
+	popts, ok := req.ProviderData.(*ProviderOpts)
+	if !ok || popts == nil {
+		resp.Diagnostics.AddError("Client Error", "invalid provider data")
+		return
+	}
+	r.popts = *popts
+}
+
+func (r *SourceCacheResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var data SourceCacheResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(r.populate(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *SourceCacheResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var data SourceCacheResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// If anything has gone missing from the cache, fetch it again.
+	var entries []struct {
+		Kind string `tfsdk:"kind"`
+		Key  string `tfsdk:"key"`
+		URI  string `tfsdk:"uri"`
+		Path string `tfsdk:"path"`
+	}
+	resp.Diagnostics.Append(data.Entries.ElementsAs(ctx, &entries, false)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	for _, e := range entries {
+		if e.Key != "" && !(cacheEntry{Key: e.Key}).cached(r.popts.cacheDir()) {
+			tflog.Info(ctx, fmt.Sprintf("%s is missing from the cache", e.Key))
+			resp.State.RemoveResource(ctx)
+			return
+		}
+	}
+
+	// Save updated data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *SourceCacheResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var data SourceCacheResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(r.populate(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Save updated data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *SourceCacheResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var data SourceCacheResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	// Nothing to delete; cached sources are content-addressed and may be
+	// shared with other configurations.
+}
+
+// populate fetches every source of the configurations that isn't already
+// cached, and sets the computed attributes.
+func (r *SourceCacheResource) populate(ctx context.Context, data *SourceCacheResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	var contents, override []string
+	diags.Append(data.ConfigContents.ElementsAs(ctx, &contents, false)...)
+	diags.Append(data.Archs.ElementsAs(ctx, &override, false)...)
+	if diags.HasError() {
+		return diags
+	}
+
+	seen := map[string]bool{}
+	var entries []cacheEntry
+	for i, c := range contents {
+		archs, err := r.archs([]byte(c), override)
+		if err != nil {
+			diags.AddError("Invalid configuration", fmt.Sprintf("config_contents[%d]: %v", i, err))
+			continue
+		}
+		found, err := sourceCacheEntries([]byte(c), archs)
+		if err != nil {
+			diags.AddError("Invalid configuration", fmt.Sprintf("config_contents[%d]: %v", i, err))
+			continue
+		}
+		for _, e := range found {
+			if !e.cacheable() {
+				continue
+			}
+			if e.Key == "" {
+				diags.AddWarning("Source can't be cached", fmt.Sprintf("config_contents[%d] %s: %s %s has no expected checksum to cache it by", i, e.Path, e.Kind, e.URI))
+				continue
+			}
+			if seen[e.Key] {
+				continue
+			}
+			seen[e.Key] = true
+			entries = append(entries, e)
+		}
+	}
+	if diags.HasError() {
+		return diags
+	}
+
+	var missing []string
+	for _, e := range entries {
+		if e.cached(r.popts.cacheDir()) {
+			tflog.Trace(ctx, fmt.Sprintf("%s is already cached", e.Key))
+			continue
+		}
+		if r.popts.offline {
+			missing = append(missing, fmt.Sprintf("%s (%s)", e.Key, e.URI))
+			continue
+		}
+		tflog.Info(ctx, fmt.Sprintf("caching %s from %s", e.Key, e.URI))
+		if err := e.fetch(ctx, r.popts.cacheDir()); err != nil {
+			diags.AddError("Error fetching source", err.Error())
+		}
+	}
+	if len(missing) != 0 {
+		diags.AddError("Sources missing from cache", fmt.Sprintf("offline mode: %d source(s) are missing from %s:\n  %s", len(missing), r.popts.cacheDir(), strings.Join(missing, "\n  ")))
+	}
+	if diags.HasError() {
+		return diags
+	}
+
+	vals := make([]attr.Value, 0, len(entries))
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		vals = append(vals, e.value())
+		keys = append(keys, e.Key)
+	}
+	data.Entries = basetypes.NewListValueMust(cacheEntryType, vals)
+	data.Id = types.StringValue(fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(keys, "\n")))))
+	return diags
+}
+
+// archs returns the architectures to evaluate a configuration's sources for.
+func (r *SourceCacheResource) archs(contents []byte, override []string) ([]apkotypes.Architecture, error) {
+	targets, err := targetArchitectures(contents)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseMelangeConfig(contents)
+	if err != nil {
+		return nil, err
+	}
+	configArchs := make([]string, 0, len(cfg.Environment.Archs))
+	for _, a := range cfg.Environment.Archs {
+		configArchs = append(configArchs, a.String())
+	}
+	archs, err := effectiveArchs(targets, override, configArchs, r.popts.archs)
+	if err != nil || len(archs) != 0 {
+		return archs, err
+	}
+	// Without any defaults, consider every architecture the config allows.
+	all := make([]string, 0, len(apkotypes.AllArchs))
+	for _, a := range apkotypes.AllArchs {
+		all = append(all, a.String())
+	}
+	return effectiveArchs(targets, nil, nil, all)
+}
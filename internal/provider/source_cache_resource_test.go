@@ -0,0 +1,57 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+)
+
+func TestAccSourceCacheResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+resource "melange_source_cache" "cache" {
+	config_contents = [file("${path.module}/testdata/minimal.yaml")]
+}`,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("melange_source_cache.cache", "entries.#", "0"),
+			),
+		}},
+	})
+}
+
+func TestAccSourceCacheResource_Offline(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: map[string]func() (tfprotov6.ProviderServer, error){
+			"melange": providerserver.NewProtocol6WithError(&Provider{}),
+		},
+		Steps: []resource.TestStep{{
+			Config: `
+provider "melange" {
+	offline = true
+}
+
+resource "melange_source_cache" "cache" {
+	config_contents = [<<EOT
+package:
+  name: fetched
+  version: 1.0.0
+pipeline:
+  - uses: fetch
+    with:
+      uri: https://example.com/fetched-1.0.0.tar.gz
+      expected-sha256: 0000000000000000000000000000000000000000000000000000000000000000
+EOT
+	]
+}`,
+			ExpectError: regexp.MustCompile(`sha256:0{64} \(https://example.com/fetched-1.0.0.tar.gz\)`),
+		}},
+	})
+}
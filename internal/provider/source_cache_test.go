@@ -0,0 +1,106 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+)
+
+func TestSourceCacheEntries(t *testing.T) {
+	contents := []byte(`
+package:
+  name: foo
+  version: 1.2.3
+vars:
+  mirror: https://example.com
+pipeline:
+  - uses: fetch
+    with:
+      uri: ${{vars.mirror}}/foo-${{package.version}}-${{build.arch}}.tar.gz
+      expected-sha256: abc
+  - uses: git-checkout
+    with:
+      repository: https://github.com/example/foo
+      tag: v${{package.version}}
+      expected-commit: deadbeef
+  - uses: fetch
+    with:
+      uri: https://example.com/unpinned.tar.gz
+subpackages:
+  - name: foo-extra
+    pipeline:
+      - uses: fetch
+        with:
+          uri: https://example.com/extra.tar.gz
+          expected-sha512: def
+`)
+	got, err := sourceCacheEntries(contents, []apkotypes.Architecture{apkotypes.ParseArchitecture("x86_64")})
+	if err != nil {
+		t.Fatalf("sourceCacheEntries: %v", err)
+	}
+	want := []cacheEntry{
+		{Kind: "fetch", URI: "https://example.com/unpinned.tar.gz", Path: "pipeline[2]"},
+		{Kind: "git-checkout", Key: "git:deadbeef", URI: "https://github.com/example/foo", Ref: "v1.2.3", Path: "pipeline[1]"},
+		{Kind: "fetch", Key: "sha256:abc", URI: "https://example.com/foo-1.2.3-x86_64.tar.gz", Path: "pipeline[0]"},
+		{Kind: "fetch", Key: "sha512:def", URI: "https://example.com/extra.tar.gz", Path: "subpackages[0].pipeline[0]"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries:\n%+v\nwant:\n%+v", got, want)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sha512:def"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := missingCacheEntries(dir, got)
+	// The git-checkout is always cloned from the network, so it's always
+	// missing.
+	if len(missing) != 3 {
+		t.Fatalf("got %d missing entries, want 3: %v", len(missing), missing)
+	}
+	for i, want := range []string{"has no expected checksum", "git-checkout of https://github.com/example/foo always clones", "sha256:abc"} {
+		if !strings.Contains(missing[i], want) {
+			t.Errorf("missing[%d] = %q, want it to contain %q", i, missing[i], want)
+		}
+	}
+	if got := networkOnlyEntries(got); len(got) != 1 || !strings.Contains(got[0], "pipeline[1]: git-checkout") {
+		t.Errorf("networkOnlyEntries() = %v, want the git-checkout", got)
+	}
+}
+
+func TestCacheEntryFetch(t *testing.T) {
+	body := []byte("hello")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	good := cacheEntry{Kind: "fetch", Key: fmt.Sprintf("sha256:%x", sha256.Sum256(body)), URI: srv.URL}
+	if err := good.fetch(context.Background(), dir); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if !good.cached(dir) {
+		t.Errorf("%s wasn't cached", good.Key)
+	}
+
+	bad := cacheEntry{Kind: "fetch", Key: "sha256:0000", URI: srv.URL}
+	if err := bad.fetch(context.Background(), dir); err == nil {
+		t.Errorf("fetch with the wrong checksum succeeded")
+	}
+	if bad.cached(dir) {
+		t.Errorf("%s was cached despite the checksum mismatch", bad.Key)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zealic/xignore"
+)
+
+// sourceIgnoreFile lists paths in a source directory that melange doesn't
+// copy into the build's workspace.
+const sourceIgnoreFile = ".melangeignore"
+
+// hashSourceDir returns the sha256 digest of the files melange copies from
+// dir into the build's workspace, which are the regular files not matched by
+// the directory's .melangeignore. The digest covers each file's path,
+// permissions and contents.
+func hashSourceDir(dir string) (string, error) {
+	var patterns []*xignore.Pattern
+	if f, err := os.Open(filepath.Join(dir, sourceIgnoreFile)); err == nil {
+		defer f.Close()
+		var ign xignore.Ignorefile
+		if err := ign.FromReader(f); err != nil {
+			return "", fmt.Errorf("reading %s: %w", sourceIgnoreFile, err)
+		}
+		for _, rule := range ign.Patterns {
+			p := xignore.NewPattern(rule)
+			if err := p.Prepare(); err != nil {
+				return "", fmt.Errorf("reading %s: %w", sourceIgnoreFile, err)
+			}
+			patterns = append(patterns, p)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	h := sha256.New()
+	// WalkDir visits files in lexical order, so the digest is stable.
+	if err := fs.WalkDir(os.DirFS(dir), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		for _, p := range patterns {
+			if p.Match(path) {
+				return nil
+			}
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		digest, err := fileDigest(filepath.Join(dir, path))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %04o %s\n", digest, fi.Mode().Perm(), path)
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("hashing %s: %w", dir, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// workingDirIgnoreFile writes an ignore file to scratch for builds that
+// copy the working directory into their workspace. It holds the working
+// directory's own .melangeignore rules and one for the scratch directories
+// under dir, which hold other builds' inputs. It returns the file's path
+// relative to the working directory, which is where melange looks for it.
+func workingDirIgnoreFile(dir, scratch string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	rules, err := os.ReadFile(sourceIgnoreFile)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if len(rules) != 0 && rules[len(rules)-1] != '\n' {
+		rules = append(rules, '\n')
+	}
+	parent, err := filepath.Abs(filepath.Join(dir, scratchDirName))
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(wd, parent); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rules = append(rules, fmt.Sprintf("/%s/**\n", filepath.ToSlash(rel))...)
+	}
+	path := filepath.Join(scratch, sourceIgnoreFile)
+	if err := os.WriteFile(path, rules, 0o644); err != nil {
+		return "", fmt.Errorf("writing %s: %w", sourceIgnoreFile, err)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(wd, path)
+}
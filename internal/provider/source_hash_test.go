@@ -0,0 +1,118 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zealic/xignore"
+)
+
+func TestHashSourceDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string, mode os.FileMode) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func() string {
+		t.Helper()
+		h, err := hashSourceDir(dir)
+		if err != nil {
+			t.Fatalf("hashSourceDir: %v", err)
+		}
+		return h
+	}
+
+	write("main.c", "int main() {}", 0o644)
+	write("scripts/build.sh", "make", 0o755)
+	write(sourceIgnoreFile, "*.o\nbuild/**\n", 0o644)
+	orig := hash()
+
+	write("main.o", "object", 0o644)
+	write("build/out", "output", 0o644)
+	if got := hash(); got != orig {
+		t.Errorf("ignored files changed the hash")
+	}
+
+	write("scripts/build.sh", "make", 0o644)
+	chmod := hash()
+	if chmod == orig {
+		t.Errorf("changing permissions didn't change the hash")
+	}
+
+	write("main.c", "int main() { return 1; }", 0o644)
+	if got := hash(); got == chmod {
+		t.Errorf("changing contents didn't change the hash")
+	}
+}
+
+func TestWorkingDirIgnoreFile(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if err := os.WriteFile(sourceIgnoreFile, []byte("*.o"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	scratch := filepath.Join(dir, scratchDirName, "minimal-1234")
+	if err := os.MkdirAll(scratch, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path, err := workingDirIgnoreFile("", scratch)
+	if err != nil {
+		t.Fatalf("workingDirIgnoreFile: %v", err)
+	}
+	if filepath.IsAbs(path) {
+		t.Errorf("path %s isn't relative to the working directory", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ign xignore.Ignorefile
+	if err := ign.FromReader(f); err != nil {
+		t.Fatal(err)
+	}
+	ignored := func(path string) bool {
+		for _, rule := range ign.Patterns {
+			p := xignore.NewPattern(rule)
+			if err := p.Prepare(); err != nil {
+				t.Fatal(err)
+			}
+			if p.Match(path) {
+				return true
+			}
+		}
+		return false
+	}
+	for path, want := range map[string]bool{
+		"main.c":                               false,
+		"main.o":                               true,
+		".melange-scratch/minimal-1234/a.yaml": true,
+		"src/.melange-scratch.c":               false,
+	} {
+		if got := ignored(path); got != want {
+			t.Errorf("ignored(%s) = %t, want %t", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/melange/pkg/build"
+	melangeconfig "chainguard.dev/melange/pkg/config"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"golang.org/x/sync/errgroup"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ resource.Resource = &TestResource{}
+
+func NewTestResource() resource.Resource {
+	return &TestResource{}
+}
+
+// TestResource defines the resource implementation.
+type TestResource struct {
+	popts ProviderOpts
+}
+
+// TestResourceModel describes the resource data model.
+type TestResourceModel struct {
+	ConfigContents    types.String `tfsdk:"config_contents"`
+	ConfigDir         types.String `tfsdk:"config_dir"`
+	Archs             types.List   `tfsdk:"archs"`
+	AllowFailures     types.Bool   `tfsdk:"allow_failures"`
+	ExtraRepositories types.List   `tfsdk:"extra_repositories"`
+	ExtraKeyring      types.List   `tfsdk:"extra_keyring"`
+	Dir               types.String `tfsdk:"dir"`
+	SigningKey        types.String `tfsdk:"signing_key"`
+	Runner            types.String `tfsdk:"runner"`
+	Namespace         types.String `tfsdk:"namespace"`
+	PipelineDirs      types.List   `tfsdk:"pipeline_dirs"`
+	Vars              types.Map    `tfsdk:"vars"`
+	Artifacts         types.Map    `tfsdk:"artifacts"`
+	Results           types.List   `tfsdk:"results"`
+	Passed            types.Bool   `tfsdk:"passed"`
+	Id                types.String `tfsdk:"id"`
+}
+
+var testResultType = basetypes.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"arch":   basetypes.StringType{},
+		"test":   basetypes.StringType{},
+		"status": basetypes.StringType{},
+	},
+}
+
+func (r *TestResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_test"
+}
+
+func (r *TestResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "Runs the `test` pipelines of a melange configuration against the package built by `melange_build`, installed from the local `packages` repository in `dir`. Tests run again whenever the built packages or the configuration change. Set the build settings `melange_build` overrides to the same values here.",
+
+		Attributes: map[string]schema.Attribute{
+			"config_contents": schema.StringAttribute{
+				MarkdownDescription: "The raw contents of the melange configuration, which must have a `test` section.",
+				Required:            true,
+			},
+			"config_dir": schema.StringAttribute{
+				MarkdownDescription: "The directory containing the melange configuration, usually `config_dir` from `melange_config`. If set, local pipelines are resolved relative to it instead of the provider's `dir`.",
+				Optional:            true,
+			},
+			"archs": schema.ListAttribute{
+				MarkdownDescription: "Architectures to test, overriding `environment.archs` and the provider's `default_archs`. Architectures not in the configuration's `target-architecture` are ignored.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"allow_failures": schema.BoolAttribute{
+				MarkdownDescription: "Record failing tests in `results` instead of failing the apply.",
+				Optional:            true,
+			},
+			"extra_repositories": schema.ListAttribute{
+				MarkdownDescription: "Additional repositories to search for packages, added to the provider's.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"extra_keyring": schema.ListAttribute{
+				MarkdownDescription: "Additional keys to use for package verification, added to the provider's.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"dir": schema.StringAttribute{
+				MarkdownDescription: "Directory the package was built in, overriding the provider's `dir`.",
+				Optional:            true,
+			},
+			"signing_key": schema.StringAttribute{
+				MarkdownDescription: "The path to the RSA private key the package was signed with, relative to `dir`, overriding the provider's `signing_key`. Its public key is trusted to install the package.",
+				Optional:            true,
+			},
+			"runner": schema.StringAttribute{
+				MarkdownDescription: "The runner to run the tests with, overriding the provider's `runner`.",
+				Optional:            true,
+			},
+			"namespace": schema.StringAttribute{
+				MarkdownDescription: "The namespace to use for the tests, overriding the provider's `namespace`.",
+				Optional:            true,
+			},
+			"pipeline_dirs": schema.ListAttribute{
+				MarkdownDescription: "Directories to load local pipelines from, searched in order before the default pipelines directory.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"vars": schema.MapAttribute{
+				MarkdownDescription: "Values to set in the configuration's `vars`, overriding those in the configuration.",
+				Optional:            true,
+				ElementType:         types.StringType,
+			},
+			"artifacts": schema.MapAttribute{
+				MarkdownDescription: "The sha256 digest of the tested package, by architecture.",
+				Computed:            true,
+				ElementType:         types.StringType,
+			},
+			"results": schema.ListAttribute{
+				MarkdownDescription: "The `status` of each `test` step for each `arch`: `pass`, `fail`, or `not run` if an earlier step failed.",
+				Computed:            true,
+				ElementType:         testResultType,
+			},
+			"passed": schema.BoolAttribute{
+				MarkdownDescription: "Whether every test passed on every architecture.",
+				Computed:            true,
+			},
+			"id": schema.StringAttribute{
+				Computed:            true,
+				MarkdownDescription: "Identifier of the resource",
+				PlanModifiers:       []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+		},
+	}
+}
+
+func (r *TestResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+
+	popts, ok := req.ProviderData.(*ProviderOpts)
+	if !ok || popts == nil {
+		resp.Diagnostics.AddError("Client Error", "invalid provider data")
+		return
+	}
+	r.popts = *popts
+}
+
+func (r *TestResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var data TestResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := r.doTest(ctx, &data); err != nil {
+		resp.Diagnostics.AddError("Test Failure", err.Error())
+		return
+	}
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *TestResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var data TestResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// If the packages have been rebuilt since they were tested, test them again.
+	var artifacts map[string]string
+	resp.Diagnostics.Append(data.Artifacts.ElementsAs(ctx, &artifacts, false)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	r, err := r.withOverrides(ctx, data)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	pkg, err := parseMelangeConfig([]byte(data.ConfigContents.ValueString()))
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", err.Error())
+		return
+	}
+	apks := r.apks(pkg.Package)
+	for arch, digest := range artifacts {
+		got, err := fileDigest(apks.path(apkotypes.ParseArchitecture(arch), pkg.Package.Name))
+		if err != nil || got != digest {
+			tflog.Info(ctx, fmt.Sprintf("package for %s changed since it was tested", arch))
+			resp.State.RemoveResource(ctx)
+			return
+		}
+	}
+
+	// Save updated data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *TestResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var data TestResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := r.doTest(ctx, &data); err != nil {
+		resp.Diagnostics.AddError("Test Failure", err.Error())
+		return
+	}
+
+	// Save updated data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *TestResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var data TestResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	// Nothing to delete.
+}
+
+// withOverrides returns a copy of the resource whose provider options are
+// overridden by the test's own settings.
+func (r *TestResource) withOverrides(ctx context.Context, data TestResourceModel) (*TestResource, error) {
+	o, err := r.popts.withOverrides(ctx, buildOverrides{
+		extraRepositories: data.ExtraRepositories,
+		extraKeyring:      data.ExtraKeyring,
+		dir:               data.Dir,
+		signingKey:        data.SigningKey,
+		runner:            data.Runner,
+		namespace:         data.Namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TestResource{popts: o}, nil
+}
+
+// apks names the APK of the package under test, where melange_build puts it.
+func (r *TestResource) apks(pkg melangeconfig.Package) builtAPKs {
+	return builtAPKs{dir: filepath.Join(r.popts.dir, "packages"), version: pkg.Version, epoch: pkg.Epoch, names: []string{pkg.Name}}
+}
+
+// doTest runs the test pipelines for each arch and sets the computed
+// attributes. It returns an error if the tests couldn't be run, or if any
+// failed and failures aren't allowed.
+func (r *TestResource) doTest(ctx context.Context, data *TestResourceModel) error {
+	r, err := r.withOverrides(ctx, *data)
+	if err != nil {
+		return err
+	}
+	contents := []byte(data.ConfigContents.ValueString())
+	_, test, err := splitTestSection(contents)
+	if err != nil {
+		return fmt.Errorf("parsing config: %w", err)
+	}
+	if test == nil {
+		return fmt.Errorf("config has no test section")
+	}
+	cfg, err := parseMelangeConfig(contents)
+	if err != nil {
+		return fmt.Errorf("parsing config: %w", err)
+	}
+	testContents, names, err := testConfig(cfg.Package, test)
+	if err != nil {
+		return err
+	}
+	// Test steps see the configuration's vars, with the test's own applied.
+	vars := map[string]string{}
+	for k, v := range cfg.Vars {
+		vars[k] = v
+	}
+	var override map[string]string
+	if diags := data.Vars.ElementsAs(ctx, &override, false); diags.HasError() {
+		return fmt.Errorf("reading vars: %v", diags.Errors())
+	}
+	for k, v := range override {
+		vars[k] = v
+	}
+	if testContents, err = withVars(testContents, vars); err != nil {
+		return fmt.Errorf("setting vars: %w", err)
+	}
+
+	var archOverride []string
+	if diags := data.Archs.ElementsAs(ctx, &archOverride, false); diags.HasError() {
+		return fmt.Errorf("reading archs: %v", diags.Errors())
+	}
+	targets, err := targetArchitectures(contents)
+	if err != nil {
+		return fmt.Errorf("parsing config: %w", err)
+	}
+	configArchs := make([]string, 0, len(cfg.Environment.Archs))
+	for _, a := range cfg.Environment.Archs {
+		configArchs = append(configArchs, a.String())
+	}
+	archs, err := effectiveArchs(targets, archOverride, configArchs, r.popts.archs)
+	if err != nil {
+		return err
+	}
+
+	// Tests install the package from the local repository, so trust the key
+	// it's signed with.
+	keyring := r.popts.keyring
+	if pub := filepath.Join(r.popts.dir, r.popts.signingKey+".pub"); fileExists(pub) {
+		keyring = append(append([]string(nil), keyring...), pub)
+	}
+	localRepo, err := filepath.Abs(filepath.Join(r.popts.dir, "packages"))
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	artifacts := map[string]attr.Value{}
+	results := map[string]map[string]string{}
+	var failures []string
+	var errg errgroup.Group
+	for _, arch := range archs {
+		arch := arch
+		errg.Go(func() error {
+			apk := r.apks(cfg.Package).path(arch, cfg.Package.Name)
+			digest, err := fileDigest(apk)
+			if err != nil {
+				return fmt.Errorf("%s hasn't been built for %s: %w", cfg.Package.Name, arch, err)
+			}
+
+			started, err := r.runTests(ctx, arch, cfg.Package.Name, testContents, localRepo, keyring, data.ConfigDir.ValueString(), data.PipelineDirs)
+			res := testResults(names, started, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			artifacts[arch.ToAPK()] = types.StringValue(digest)
+			results[arch.ToAPK()] = res
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", arch.ToAPK(), err))
+			}
+			return nil
+		})
+	}
+	if err := errg.Wait(); err != nil {
+		return err
+	}
+
+	sort.Strings(failures)
+	if len(failures) != 0 && !data.AllowFailures.ValueBool() {
+		return fmt.Errorf("tests for %s failed:\n  %s", cfg.Package.Name, strings.Join(failures, "\n  "))
+	}
+
+	var vals []attr.Value
+	for _, arch := range archs {
+		for _, n := range names {
+			vals = append(vals, basetypes.NewObjectValueMust(testResultType.AttrTypes, map[string]attr.Value{
+				"arch":   types.StringValue(arch.ToAPK()),
+				"test":   types.StringValue(n),
+				"status": types.StringValue(results[arch.ToAPK()][n]),
+			}))
+		}
+	}
+	data.Artifacts = types.MapValueMust(types.StringType, artifacts)
+	data.Results = types.ListValueMust(testResultType, vals)
+	data.Passed = types.BoolValue(len(failures) == 0)
+
+	h := sha256.New()
+	h.Write(contents)
+	for _, arch := range archs {
+		fmt.Fprintf(h, "\n%s=%s", arch.ToAPK(), artifacts[arch.ToAPK()].(types.String).ValueString())
+	}
+	data.Id = types.StringValue(fmt.Sprintf("%x", h.Sum(nil)))
+	return nil
+}
+
+// runTests runs the test configuration for arch, returning the test steps
+// that were started.
+func (r *TestResource) runTests(ctx context.Context, arch apkotypes.Architecture, name string, contents []byte, localRepo string, keyring []string, configDir string, pipelineDirs types.List) ([]string, error) {
+	// The test package is built into a scratch directory and thrown away.
+	scratch, err := os.MkdirTemp("", name+"-test-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating scratch directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+	configPath := filepath.Join(scratch, name+"-test.yaml")
+	if err := os.WriteFile(configPath, contents, 0o644); err != nil {
+		return nil, fmt.Errorf("writing test config: %w", err)
+	}
+	pipelineDir, err := r.popts.localPipelines(ctx, configDir, pipelineDirs, scratch)
+	if err != nil {
+		return nil, err
+	}
+
+	logPath := filepath.Join(r.popts.dir, "packages", arch.ToAPK(), name+".test.log")
+	if err := os.Remove(logPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("removing old test log: %w", err)
+	}
+	opts := []build.Option{build.WithArch(arch),
+		build.WithConfig(configPath),
+		build.WithExtraRepos(append([]string{localRepo}, r.popts.repositories...)),
+		build.WithExtraKeys(keyring),
+		build.WithPipelineDir(pipelineDir),
+		build.WithOutDir(filepath.Join(scratch, "packages")),
+		build.WithRunner(r.popts.runner),
+		build.WithCacheDir(r.popts.cacheDir()),
+		build.WithEmptyWorkspace(true),
+		// TF swallows logs, so write logs to a file.
+		build.WithLogPolicy([]string{logPath}),
+		build.WithGenerateIndex(false),
+	}
+	if r.popts.namespace != "" {
+		opts = append(opts, build.WithNamespace(r.popts.namespace))
+	}
+	bc, err := r.popts.newBuild(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("setting up tests: %w", err)
+	}
+	steps := &stepLogger{Logger: bc.Logger}
+	bc.Logger = steps
+	err = buildPackage(ctx, bc, false)
+	return steps.Started(), err
+}
+
+// fileDigest returns the hex-encoded sha256 digest of the file.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
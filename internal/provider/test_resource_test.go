@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+)
+
+func TestAccTestResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: `
+data "melange_config" "tested" {
+	config_file = "testdata/tested.yaml"
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.tested.config
+	config_contents = data.melange_config.tested.config_contents
+}
+
+resource "melange_test" "test" {
+	config_contents = data.melange_config.tested.config_contents
+
+	depends_on = [melange_build.build]
+}`,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("melange_test.test", "passed", "true"),
+				resource.TestCheckResourceAttr("melange_test.test", "results.#", "1"),
+				resource.TestCheckResourceAttr("melange_test.test", "results.0.arch", arch),
+				resource.TestCheckResourceAttr("melange_test.test", "results.0.test", "hello is installed"),
+				resource.TestCheckResourceAttr("melange_test.test", "results.0.status", "pass"),
+				resource.TestCheckResourceAttrSet("melange_test.test", "artifacts."+arch),
+			),
+		}},
+	})
+}
+
+func TestAccTestResource_Dir(t *testing.T) {
+	// A package built with a dir override is found in that dir.
+	dir := t.TempDir()
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{{
+			Config: fmt.Sprintf(`
+data "melange_config" "tested" {
+	config_file = "testdata/tested.yaml"
+}
+
+resource "melange_build" "build" {
+	config          = data.melange_config.tested.config
+	config_contents = data.melange_config.tested.config_contents
+	dir             = %[1]q
+}
+
+resource "melange_test" "test" {
+	config_contents = data.melange_config.tested.config_contents
+	dir             = %[1]q
+
+	depends_on = [melange_build.build]
+}`, dir),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("melange_test.test", "passed", "true"),
+				resource.TestCheckResourceAttrSet("melange_test.test", "artifacts."+arch),
+			),
+		}},
+	})
+}
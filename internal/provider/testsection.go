@@ -0,0 +1,147 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"fmt"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	melangeconfig "chainguard.dev/melange/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+// testSection is the `test` section of a melange configuration, describing
+// pipelines to run against the built package.
+//
+// The version of melange this provider is built with doesn't know about
+// tests, so the section is removed before configurations are handed to it,
+// and tests are run as a build of a package that depends on the one under
+// test.
+type testSection struct {
+	Environment apkotypes.ImageConfiguration `yaml:"environment,omitempty"`
+	Pipeline    []melangeconfig.Pipeline     `yaml:"pipeline,omitempty"`
+}
+
+// splitTestSection returns the configuration without its `test` section,
+// along with the section, which is nil if there isn't one.
+func splitTestSection(contents []byte) ([]byte, *yaml.Node, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil {
+		return nil, nil, err
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return contents, nil, nil
+	}
+	doc := root.Content[0]
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != "test" {
+			continue
+		}
+		test := doc.Content[i+1]
+		doc.Content = append(doc.Content[:i:i], doc.Content[i+2:]...)
+		rest, err := yaml.Marshal(&root)
+		if err != nil {
+			return nil, nil, err
+		}
+		return rest, test, nil
+	}
+	return contents, nil, nil
+}
+
+// testConfig returns a melange configuration that runs the test pipelines
+// against the given package, along with the names of the test steps in the
+// order they run. Steps without a name are given one, so that failures can
+// be attributed to them.
+func testConfig(pkg melangeconfig.Package, test *yaml.Node) ([]byte, []string, error) {
+	var section struct {
+		Environment map[string]any   `yaml:"environment"`
+		Pipeline    []map[string]any `yaml:"pipeline"`
+	}
+	if err := test.Decode(&section); err != nil {
+		return nil, nil, fmt.Errorf("test: %w", err)
+	}
+	if len(section.Pipeline) == 0 {
+		return nil, nil, fmt.Errorf("test: no pipeline to run")
+	}
+
+	env := section.Environment
+	if env == nil {
+		env = map[string]any{}
+	}
+	contents, _ := env["contents"].(map[string]any)
+	if contents == nil {
+		contents = map[string]any{}
+	}
+	packages, _ := contents["packages"].([]any)
+	contents["packages"] = append(packages, fmt.Sprintf("%s=%s-r%d", pkg.Name, pkg.Version, pkg.Epoch))
+	env["contents"] = contents
+
+	names := make([]string, 0, len(section.Pipeline))
+	seen := map[string]bool{}
+	for i, step := range section.Pipeline {
+		name, _ := step["name"].(string)
+		if name == "" {
+			name, _ = step["uses"].(string)
+		}
+		if name == "" || seen[name] {
+			name = fmt.Sprintf("test.pipeline[%d]", i)
+		}
+		seen[name] = true
+		step["name"] = name
+		names = append(names, name)
+	}
+
+	out, err := yaml.Marshal(map[string]any{
+		"package": map[string]any{
+			"name":        pkg.Name + "-test",
+			"version":     pkg.Version,
+			"epoch":       pkg.Epoch,
+			"description": fmt.Sprintf("tests for %s-%s-r%d", pkg.Name, pkg.Version, pkg.Epoch),
+			// The package only exists to run the tests, and is always empty.
+			"checks": map[string]any{"disabled": []string{"empty"}},
+		},
+		"environment": env,
+		"pipeline":    section.Pipeline,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, names, nil
+}
+
+// testResults attributes the outcome of a test run to each test step, given
+// the steps that started, in order, and the run's error. Steps before the
+// last one to start passed; if the run failed, that step failed and the ones
+// after it didn't run.
+func testResults(names, started []string, err error) map[string]string {
+	results := make(map[string]string, len(names))
+	status := "pass"
+	if err != nil {
+		status = "not run"
+	}
+	for _, n := range names {
+		results[n] = status
+	}
+	if err == nil {
+		return results
+	}
+
+	index := map[string]int{}
+	for i, n := range names {
+		index[n] = i
+	}
+	last := -1
+	for _, s := range started {
+		if i, ok := index[s]; ok && i > last {
+			last = i
+		}
+	}
+	for i := 0; i <= last; i++ {
+		results[names[i]] = "pass"
+	}
+	if last >= 0 {
+		results[names[last]] = "fail"
+	}
+	return results
+}
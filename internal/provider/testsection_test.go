@@ -0,0 +1,118 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	melangeconfig "chainguard.dev/melange/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+const testConfigYAML = `
+package:
+  name: hello
+  version: 1.0.0
+  epoch: 2
+pipeline:
+  - runs: echo hi
+test:
+  environment:
+    contents:
+      packages:
+        - busybox
+  pipeline:
+    - runs: hello --version
+    - name: greets
+      runs: hello | grep hi
+    - uses: fetch
+      with:
+        uri: https://example.com/x.tar.gz
+        expected-sha256: abc
+`
+
+func TestSplitTestSection(t *testing.T) {
+	rest, test, err := splitTestSection([]byte(testConfigYAML))
+	if err != nil {
+		t.Fatalf("splitTestSection: %v", err)
+	}
+	if test == nil {
+		t.Fatal("test section not found")
+	}
+	if strings.Contains(string(rest), "test:") {
+		t.Errorf("test section wasn't removed:\n%s", rest)
+	}
+	if _, err := parseMelangeConfig([]byte(testConfigYAML)); err != nil {
+		t.Errorf("parseMelangeConfig: %v", err)
+	}
+
+	rest, test, err = splitTestSection([]byte("package:\n  name: foo\n"))
+	if err != nil || test != nil || string(rest) != "package:\n  name: foo\n" {
+		t.Errorf("got (%q, %v, %v), want config unchanged without a test section", rest, test, err)
+	}
+}
+
+func TestTestConfig(t *testing.T) {
+	_, test, err := splitTestSection([]byte(testConfigYAML))
+	if err != nil {
+		t.Fatalf("splitTestSection: %v", err)
+	}
+	out, names, err := testConfig(melangeconfig.Package{Name: "hello", Version: "1.0.0", Epoch: 2}, test)
+	if err != nil {
+		t.Fatalf("testConfig: %v", err)
+	}
+	if want := []string{"test.pipeline[0]", "greets", "fetch"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got names %v, want %v", names, want)
+	}
+
+	var cfg melangeconfig.Configuration
+	dec := yaml.NewDecoder(strings.NewReader(string(out)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		t.Fatalf("test config isn't a valid melange config: %v\n%s", err, out)
+	}
+	if cfg.Package.Name != "hello-test" {
+		t.Errorf("got package name %q, want hello-test", cfg.Package.Name)
+	}
+	if want := []string{"busybox", "hello=1.0.0-r2"}; !reflect.DeepEqual(cfg.Environment.Contents.Packages, want) {
+		t.Errorf("got packages %v, want %v", cfg.Environment.Contents.Packages, want)
+	}
+	for i, p := range cfg.Pipeline {
+		if p.Name != names[i] {
+			t.Errorf("pipeline[%d] has name %q, want %q", i, p.Name, names[i])
+		}
+	}
+}
+
+func TestTestResults(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	for _, c := range []struct {
+		desc    string
+		started []string
+		err     error
+		want    map[string]string
+	}{{
+		desc:    "all pass",
+		started: []string{"a", "nested", "b", "c"},
+		want:    map[string]string{"a": "pass", "b": "pass", "c": "pass"},
+	}, {
+		desc:    "second fails",
+		started: []string{"a", "b", "nested"},
+		err:     errors.New("exit status 1"),
+		want:    map[string]string{"a": "pass", "b": "fail", "c": "not run"},
+	}, {
+		desc: "setup fails",
+		err:  errors.New("unable to build guest"),
+		want: map[string]string{"a": "not run", "b": "not run", "c": "not run"},
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			if got := testResults(names, c.started, c.err); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
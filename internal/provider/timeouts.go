@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	apkolog "chainguard.dev/apko/pkg/log"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// timeoutsBlock is the `timeouts` block for resources whose operations can
+// be bounded, in the same shape as the terraform-plugin-framework-timeouts
+// module.
+func timeoutsBlock(ops ...string) schema.Block {
+	attrs := make(map[string]schema.Attribute, len(ops))
+	for _, op := range ops {
+		attrs[op] = schema.StringAttribute{
+			MarkdownDescription: fmt.Sprintf("How long to wait for %s to complete, as a duration string like `\"30m\"` or `\"2h45m\"`. There is no limit by default.", op),
+			Optional:            true,
+		}
+	}
+	return schema.SingleNestedBlock{
+		MarkdownDescription: "Time limits for building the package. When a limit is reached, the running builds are cancelled and their containers torn down.",
+		Attributes:          attrs,
+	}
+}
+
+// timeout returns the duration configured for op in a `timeouts` block, or
+// zero if none is set.
+func timeout(timeouts types.Object, op string) (time.Duration, error) {
+	if timeouts.IsNull() || timeouts.IsUnknown() {
+		return 0, nil
+	}
+	v, ok := timeouts.Attributes()[op].(types.String)
+	if !ok || v.IsNull() || v.IsUnknown() {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v.ValueString())
+	if err != nil {
+		return 0, fmt.Errorf("timeouts.%s: %w", op, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("timeouts.%s: %q must be positive", op, v.ValueString())
+	}
+	return d, nil
+}
+
+// withTimeout returns a context bounded by the timeout configured for op, if
+// any.
+func withTimeout(ctx context.Context, timeouts types.Object, op string) (context.Context, context.CancelFunc, time.Duration, error) {
+	d, err := timeout(timeouts, op)
+	if err != nil || d == 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return ctx, cancel, d, nil
+}
+
+// stepLogger wraps a build's logger to remember which pipeline step is
+// running, so that builds cut short can say where they stopped.
+type stepLogger struct {
+	apkolog.Logger
+
+	mu      sync.Mutex
+	started []string
+}
+
+func (l *stepLogger) Printf(format string, args ...any) {
+	if strings.HasPrefix(format, "running step ") && len(args) == 1 {
+		l.mu.Lock()
+		l.started = append(l.started, fmt.Sprint(args[0]))
+		l.mu.Unlock()
+	}
+	l.Logger.Printf(format, args...)
+}
+
+// Step returns the most recently started pipeline step, if any.
+func (l *stepLogger) Step() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.started) == 0 {
+		return ""
+	}
+	return l.started[len(l.started)-1]
+}
+
+// Started returns the names of the pipeline steps started so far, in order.
+func (l *stepLogger) Started() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.started...)
+}
@@ -0,0 +1,296 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	apkotypes "chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/melange/pkg/build"
+	melangeconfig "chainguard.dev/melange/pkg/config"
+	"chainguard.dev/melange/pkg/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// configError describes a problem found in a melange configuration, along
+// with the key path and YAML position where it was found.
+type configError struct {
+	Path         string
+	Line, Column int
+	Message      string
+}
+
+func (e configError) Error() string {
+	var where []string
+	if e.Path != "" {
+		where = append(where, e.Path)
+	}
+	switch {
+	case e.Column != 0:
+		where = append(where, fmt.Sprintf("line %d, column %d", e.Line, e.Column))
+	case e.Line != 0:
+		where = append(where, fmt.Sprintf("line %d", e.Line))
+	}
+	if len(where) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(where, ", "), e.Message)
+}
+
+var (
+	// These match what melange and apk-tools accept, respectively.
+	packageNameRegex    = regexp.MustCompile(`^[a-zA-Z\d][a-zA-Z\d+_.-]*$`)
+	packageVersionRegex = regexp.MustCompile(`^([0-9]+)((\.[0-9]+)*)([a-z]?)((_alpha|_beta|_pre|_rc)([0-9]*))?((_cvs|_svn|_git|_hg|_p)([0-9]*))?$`)
+
+	// yaml.v3 reports errors as "line N: ..." strings.
+	yamlLineRegex     = regexp.MustCompile(`line (\d+): (.*)$`)
+	unknownFieldRegex = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+)
+
+// configWithTest is a melange configuration along with its test section,
+// which the version of melange this provider is built with doesn't know
+// about.
+type configWithTest struct {
+	melangeconfig.Configuration `yaml:",inline"`
+	Test                        *testSection `yaml:"test,omitempty"`
+}
+
+// validateConfig strictly decodes the melange configuration, rejecting
+// unknown keys, and checks the result for problems melange would otherwise
+// only report at build time. Pipelines referenced by `uses` are looked up in
+// the builtin set and in pipelineDir.
+func validateConfig(contents []byte, pipelineDir string) []configError {
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil {
+		return yamlErrors(&root, err)
+	}
+	if len(root.Content) == 0 {
+		return []configError{{Message: "configuration is empty"}}
+	}
+	doc := root.Content[0]
+
+	// Decoding errors don't stop validation, so that all problems can be
+	// reported at once.
+	var errs []configError
+	var cfg configWithTest
+	dec := yaml.NewDecoder(strings.NewReader(string(contents)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		errs = yamlErrors(doc, err)
+	}
+
+	report := func(path string, n *yaml.Node, format string, args ...any) {
+		for _, e := range errs {
+			if e.Path == path {
+				// Already reported while decoding.
+				return
+			}
+		}
+		e := configError{Path: path, Message: fmt.Sprintf(format, args...)}
+		if n != nil {
+			e.Line, e.Column = n.Line, n.Column
+		}
+		errs = append(errs, e)
+	}
+
+	pkg := lookup(doc, "package")
+	if pkg == nil {
+		report("package", doc, "required field is missing")
+		return errs
+	}
+
+	if name := lookup(pkg, "name"); name == nil {
+		report("package.name", pkg, "required field is missing")
+	} else if !packageNameRegex.MatchString(name.Value) {
+		report("package.name", name, "%q must match %q", name.Value, packageNameRegex)
+	}
+
+	if version := lookup(pkg, "version"); version == nil {
+		report("package.version", pkg, "required field is missing")
+	} else if !packageVersionRegex.MatchString(version.Value) {
+		report("package.version", version, "%q is not a valid apk version", version.Value)
+	}
+
+	if epoch := lookup(pkg, "epoch"); epoch != nil {
+		if _, err := strconv.ParseUint(epoch.Value, 10, 32); err != nil {
+			report("package.epoch", epoch, "%q must be a non-negative integer", epoch.Value)
+		}
+	}
+
+	for i, arch := range sequence(lookup(pkg, "target-architecture")) {
+		if arch.Value != "all" && !knownArch(arch.Value) {
+			report(fmt.Sprintf("package.target-architecture[%d]", i), arch, "unknown architecture %q", arch.Value)
+		}
+	}
+	for i, arch := range sequence(lookup(lookup(doc, "environment"), "archs")) {
+		if !knownArch(arch.Value) {
+			report(fmt.Sprintf("environment.archs[%d]", i), arch, "unknown architecture %q", arch.Value)
+		}
+	}
+
+	for i, sp := range sequence(lookup(doc, "subpackages")) {
+		spath := fmt.Sprintf("subpackages[%d]", i)
+		if name := lookup(sp, "name"); name == nil {
+			report(spath+".name", sp, "required field is missing")
+		} else if !packageNameRegex.MatchString(name.Value) && !strings.Contains(name.Value, "${{") {
+			report(spath+".name", name, "%q must match %q", name.Value, packageNameRegex)
+		}
+	}
+
+	walkPipelines(doc, func(path string, p *yaml.Node) {
+		if uses := lookup(p, "uses"); uses != nil && !knownPipeline(uses.Value, pipelineDir) {
+			report(path+".uses", uses, "unknown pipeline %q", uses.Value)
+		}
+	})
+
+	return errs
+}
+
+// walkPipelines calls fn for every pipeline step in the document, including
+// nested steps, subpackage pipelines and test pipelines, along with its key
+// path.
+func walkPipelines(doc *yaml.Node, fn func(path string, p *yaml.Node)) {
+	var walk func(path string, seq *yaml.Node)
+	walk = func(path string, seq *yaml.Node) {
+		for i, p := range sequence(seq) {
+			ppath := fmt.Sprintf("%s[%d]", path, i)
+			fn(ppath, p)
+			walk(ppath+".pipeline", lookup(p, "pipeline"))
+		}
+	}
+	walk("pipeline", lookup(doc, "pipeline"))
+	for i, sp := range sequence(lookup(doc, "subpackages")) {
+		walk(fmt.Sprintf("subpackages[%d].pipeline", i), lookup(sp, "pipeline"))
+	}
+	walk("test.pipeline", lookup(lookup(doc, "test"), "pipeline"))
+}
+
+// yamlErrors converts errors returned by yaml.v3 into configErrors, using
+// the parsed document to find the key path and column where possible.
+func yamlErrors(doc *yaml.Node, err error) []configError {
+	msgs := []string{err.Error()}
+	var terr *yaml.TypeError
+	if errors.As(err, &terr) {
+		msgs = terr.Errors
+	}
+
+	errs := make([]configError, 0, len(msgs))
+	for _, msg := range msgs {
+		e := configError{Message: strings.TrimPrefix(msg, "yaml: ")}
+		if m := yamlLineRegex.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Message = m[2]
+			var key string
+			if fm := unknownFieldRegex.FindStringSubmatch(m[2]); fm != nil {
+				key = fm[1]
+				e.Message = fmt.Sprintf("unknown field %q", key)
+			}
+			e.Path, e.Column = locate(doc, "", e.Line, key)
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// locate finds the first node on the given line, preferring a mapping key
+// named key if one is given, and returns its key path and column.
+func locate(n *yaml.Node, path string, line int, key string) (string, int) {
+	if n == nil {
+		return "", 0
+	}
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			if p, col := locate(c, path, line, key); col != 0 {
+				return p, col
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			kpath := k.Value
+			if path != "" {
+				kpath = path + "." + k.Value
+			}
+			if k.Line == line && (key == "" || k.Value == key) {
+				return kpath, k.Column
+			}
+			if p, col := locate(v, kpath, line, key); col != 0 {
+				return p, col
+			}
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			if p, col := locate(c, fmt.Sprintf("%s[%d]", path, i), line, key); col != 0 {
+				return p, col
+			}
+		}
+	default:
+		if n.Line == line && key == "" {
+			return path, n.Column
+		}
+	}
+	return "", 0
+}
+
+// lookup returns the value of the given key in a mapping node, or nil.
+func lookup(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// sequence returns the elements of a sequence node, or nil.
+func sequence(n *yaml.Node) []*yaml.Node {
+	if n == nil || n.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return n.Content
+}
+
+func knownArch(arch string) bool {
+	parsed := apkotypes.ParseArchitecture(arch)
+	for _, a := range apkotypes.AllArchs {
+		if parsed == a {
+			return true
+		}
+	}
+	return false
+}
+
+func knownPipeline(uses, pipelineDir string) bool {
+	if pipelineDir != "" && fileExists(filepath.Join(pipelineDir, uses+".yaml")) {
+		return true
+	}
+	return builtinPipeline(uses)
+}
+
+// builtinPipeline reports whether uses is one of the pipelines embedded in
+// the version of melange this provider is built with, which can be
+// referenced without a pipeline directory. melange doesn't export them, so
+// this asks it to load the pipeline the way a build does: it's only missing
+// if melange can't find it, not if it then needs inputs that aren't given.
+func builtinPipeline(uses string) bool {
+	pb := &build.PipelineBuild{
+		Build:   &build.Build{Logger: logger.NopLogger{}},
+		Package: &build.PackageContext{Package: &melangeconfig.Package{}},
+	}
+	pctx, err := build.NewPipelineContext(&melangeconfig.Pipeline{Uses: uses}, logger.NopLogger{})
+	if err != nil {
+		return false
+	}
+	return !errors.Is(pctx.ApplyNeeds(pb), fs.ErrNotExist)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	for _, c := range []struct {
+		desc, config string
+		want         []string
+	}{{
+		desc: "valid",
+		config: `
+package:
+  name: minimal
+  version: 0.0.1
+  epoch: 3
+  target-architecture: [x86_64]
+environment:
+  contents:
+    packages: [busybox]
+pipeline:
+  - uses: fetch
+  - runs: echo hello
+`,
+	}, {
+		desc: "unknown key",
+		config: `
+package:
+  name: minimal
+  version: 0.0.1
+  epoch: 3
+enviroment:
+  contents:
+    packages: [busybox]
+`,
+		want: []string{`enviroment, line 6, column 1: unknown field "enviroment"`},
+	}, {
+		desc: "test section",
+		config: `
+package:
+  name: minimal
+  version: 0.0.1
+test:
+  environment:
+    contents:
+      packages: [busybox]
+  pipeline:
+    - runs: minimal --version
+    - uses: nonexistent
+  timeout: 5m
+`,
+		want: []string{
+			`test.timeout, line 12, column 3: unknown field "timeout"`,
+			`test.pipeline[1].uses, line 11, column 13: unknown pipeline "nonexistent"`,
+		},
+	}, {
+		desc: "missing fields",
+		config: `
+package:
+  epoch: 3
+`,
+		want: []string{
+			"package.name, line 3, column 3: required field is missing",
+			"package.version, line 3, column 3: required field is missing",
+		},
+	}, {
+		desc: "invalid values",
+		config: `
+package:
+  name: minimal
+  version: v1.0
+  epoch: -1
+  target-architecture: [sparc]
+`,
+		want: []string{
+			"package.epoch, line 5, column 3: cannot unmarshal !!int `-1` into uint64",
+			`package.version, line 4, column 12: "v1.0" is not a valid apk version`,
+			`package.target-architecture[0], line 6, column 25: unknown architecture "sparc"`,
+		},
+	}, {
+		desc: "unknown pipeline",
+		config: `
+package:
+  name: minimal
+  version: 0.0.1
+subpackages:
+  - name: minimal-dev
+    pipeline:
+      - uses: split/dev
+      - pipeline:
+          - uses: split/nope
+`,
+		want: []string{`subpackages[0].pipeline[1].pipeline[0].uses, line 10, column 19: unknown pipeline "split/nope"`},
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			errs := validateConfig([]byte(c.config), t.TempDir())
+			if len(errs) != len(c.want) {
+				t.Fatalf("got %d errors, want %d: %v", len(errs), len(c.want), errs)
+			}
+			for i, err := range errs {
+				if got := err.Error(); got != c.want[i] {
+					t.Errorf("error %d: got %q, want %q", i, got, c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuiltinPipeline(t *testing.T) {
+	for uses, want := range map[string]bool{
+		"fetch":        true,
+		"git-checkout": true,
+		// Found, even though its required inputs aren't given.
+		"go/build":   true,
+		"split/dev":  true,
+		"split/nope": false,
+		"../fetch":   false,
+	} {
+		if got := builtinPipeline(uses); got != want {
+			t.Errorf("builtinPipeline(%q) = %t, want %t", uses, got, want)
+		}
+	}
+}
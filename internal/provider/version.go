@@ -0,0 +1,114 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// apkVersionRE matches APK versions like 1.2.3b_rc1_p2-r4: dot-separated
+// numbers, an optional letter, suffixes and the package epoch.
+var apkVersionRE = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)*)([a-z]?)((?:_(?:alpha|beta|pre|rc|cvs|svn|git|hg|p)[0-9]*)*)(?:-r([0-9]+))?$`)
+
+// Suffixes sort in this order, with none between rc and cvs, so 1.0_rc1 <
+// 1.0 < 1.0_p1.
+var apkVersionSuffixes = []string{"alpha", "beta", "pre", "rc", "", "cvs", "svn", "git", "hg", "p"}
+
+type apkVersion struct {
+	numbers  []uint64
+	letter   string
+	suffixes [][2]uint64 // the suffix's rank, then its number
+	epoch    uint64
+}
+
+func parseAPKVersion(v string) (apkVersion, bool) {
+	m := apkVersionRE.FindStringSubmatch(v)
+	if m == nil {
+		return apkVersion{}, false
+	}
+	var pv apkVersion
+	for _, n := range strings.Split(m[1], ".") {
+		u, err := strconv.ParseUint(n, 10, 64)
+		if err != nil {
+			return apkVersion{}, false
+		}
+		pv.numbers = append(pv.numbers, u)
+	}
+	pv.letter = m[2]
+	for _, s := range strings.Split(m[3], "_")[1:] {
+		name := strings.TrimRight(s, "0123456789")
+		var n uint64
+		if num := s[len(name):]; num != "" {
+			var err error
+			if n, err = strconv.ParseUint(num, 10, 64); err != nil {
+				return apkVersion{}, false
+			}
+		}
+		for rank, suffix := range apkVersionSuffixes {
+			if suffix == name {
+				pv.suffixes = append(pv.suffixes, [2]uint64{uint64(rank), n})
+			}
+		}
+	}
+	if m[4] != "" {
+		e, err := strconv.ParseUint(m[4], 10, 64)
+		if err != nil {
+			return apkVersion{}, false
+		}
+		pv.epoch = e
+	}
+	return pv, true
+}
+
+// compareAPKVersions returns -1, 0 or 1 as the APK version a is older than,
+// the same as, or newer than b. Versions apk can't parse compare as strings.
+func compareAPKVersions(a, b string) int {
+	va, oka := parseAPKVersion(a)
+	vb, okb := parseAPKVersion(b)
+	if !oka || !okb {
+		return strings.Compare(a, b)
+	}
+	for i := 0; i < len(va.numbers) || i < len(vb.numbers); i++ {
+		switch {
+		case i >= len(va.numbers):
+			return -1
+		case i >= len(vb.numbers):
+			return 1
+		case va.numbers[i] != vb.numbers[i]:
+			return cmpUint(va.numbers[i], vb.numbers[i])
+		}
+	}
+	if c := strings.Compare(va.letter, vb.letter); c != 0 {
+		return c
+	}
+	none := [2]uint64{4, 0} // the rank of no suffix
+	for i := 0; i < len(va.suffixes) || i < len(vb.suffixes); i++ {
+		sa, sb := none, none
+		if i < len(va.suffixes) {
+			sa = va.suffixes[i]
+		}
+		if i < len(vb.suffixes) {
+			sb = vb.suffixes[i]
+		}
+		if sa[0] != sb[0] {
+			return cmpUint(sa[0], sb[0])
+		}
+		if sa[1] != sb[1] {
+			return cmpUint(sa[1], sb[1])
+		}
+	}
+	return cmpUint(va.epoch, vb.epoch)
+}
+
+func cmpUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,32 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import "testing"
+
+func TestCompareAPKVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0-r0", "1.0-r0", 0},
+		{"1.0-r0", "1.0-r1", -1},
+		{"1.0.1-r0", "1.0-r5", 1},
+		{"1.10-r0", "1.9-r0", 1},
+		{"1.0a-r0", "1.0-r0", 1},
+		{"1.0_rc1-r0", "1.0-r0", -1},
+		{"1.0_alpha2-r0", "1.0_beta1-r0", -1},
+		{"1.0_p1-r0", "1.0-r0", 1},
+		{"1.0_rc1-r0", "1.0_rc2-r0", -1},
+		{"20230101-r0", "20221231-r9", 1},
+		{"3.2", "3.1.9", 1},
+	} {
+		if got := compareAPKVersions(c.a, c.b); got != c.want {
+			t.Errorf("compareAPKVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+		if got := compareAPKVersions(c.b, c.a); got != -c.want {
+			t.Errorf("compareAPKVersions(%q, %q) = %d, want %d", c.b, c.a, got, -c.want)
+		}
+	}
+}